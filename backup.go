@@ -0,0 +1,188 @@
+package emssdb
+
+import (
+	"bytes"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/filter"
+	"github.com/syndtr/goleveldb/leveldb/iterator"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"os"
+	"time"
+)
+
+const (
+	bulkBatchSize = 4 * 1024 * 1024
+)
+
+// bulkWriter write a large number of keys to a leveldb in bounded batches
+type bulkWriter struct {
+	db    *leveldb.DB
+	batch leveldb.Batch
+}
+
+func (b *bulkWriter) put(key, val []byte) (err error) {
+	b.batch.Put(key, val)
+	return b.check()
+}
+
+func (b *bulkWriter) delete(key []byte) (err error) {
+	b.batch.Delete(key)
+	return b.check()
+}
+
+func (b *bulkWriter) check() (err error) {
+	if len(b.batch.Dump()) < bulkBatchSize {
+		return nil
+	}
+	return b.flush()
+}
+
+// flush write and fsync the pending keys
+func (b *bulkWriter) flush() (err error) {
+	if b.batch.Len() == 0 {
+		return nil
+	}
+	err = b.db.Write(&b.batch, &opt.WriteOptions{Sync: true})
+	b.batch.Reset()
+	return err
+}
+
+// Backup write a consistent copy of the db to dir, which must not exist.
+// The copy is taken from a snapshot, the writes continue while it runs,
+// and the copy can be opened by OpenDB.
+func (d *DB) Backup(dir string) (err error) {
+	defer d.metrics.observe("backup", time.Now(), &err)
+	o := d.options
+	o.ErrorIfExist = true
+	o.ReadOnly = false
+	return d.backup(dir, &o)
+}
+
+// BackupIncremental bring the backup in dir up to date with the db,
+// only the keys changed since the last backup are written.
+// A backup interrupted in the middle is not consistent, run it again to finish it.
+func (d *DB) BackupIncremental(dir string) (err error) {
+	defer d.metrics.observe("backupincremental", time.Now(), &err)
+	o := d.options
+	o.ErrorIfMissing = true
+	o.ReadOnly = false
+	return d.backup(dir, &o)
+}
+
+func (d *DB) backup(dir string, o *opt.Options) (err error) {
+	snap, err := d.db.GetSnapshot()
+	if err != nil {
+		return err
+	}
+	defer snap.Release()
+
+	bdb, err := leveldb.OpenFile(dir, o)
+	if err != nil {
+		return err
+	}
+	defer bdb.Close()
+
+	ro := &opt.ReadOptions{DontFillCache: true}
+	sit := snap.NewIterator(nil, ro)
+	defer sit.Release()
+	bit := bdb.NewIterator(nil, ro)
+	defer bit.Release()
+	return syncTo(&bulkWriter{db: bdb}, sit, bit)
+}
+
+// syncTo make the keys of dst the same as src by a merge walk of both
+func syncTo(w *bulkWriter, src, dst iterator.Iterator) (err error) {
+	sok, dok := src.Next(), dst.Next()
+	for sok || dok {
+		c := -1
+		if !sok {
+			c = 1
+		} else if dok {
+			c = bytes.Compare(src.Key(), dst.Key())
+		}
+		switch {
+		case c < 0: // only in src
+			err = w.put(src.Key(), src.Value())
+			sok = src.Next()
+		case c > 0: // only in dst
+			err = w.delete(dst.Key())
+			dok = dst.Next()
+		default:
+			if !bytes.Equal(src.Value(), dst.Value()) {
+				err = w.put(src.Key(), src.Value())
+			}
+			sok, dok = src.Next(), dst.Next()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err = src.Error(); err != nil {
+		return err
+	}
+	if err = dst.Error(); err != nil {
+		return err
+	}
+	return w.flush()
+}
+
+// Restore replace the db at path by the backup in backupDir.
+// The backup is validated, copied next to path and then swapped in,
+// the backup itself is kept. The db at path must be closed.
+func Restore(backupDir, path string) (err error) {
+	bdb, err := leveldb.OpenFile(backupDir, &opt.Options{ErrorIfMissing: true, ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer bdb.Close()
+
+	tmp := path + ".restore"
+	if err = os.RemoveAll(tmp); err != nil {
+		return err
+	}
+	if err = restoreCopy(bdb, tmp); err != nil {
+		os.RemoveAll(tmp)
+		return err
+	}
+
+	old := path + ".old"
+	if err = os.RemoveAll(old); err != nil {
+		return err
+	}
+	if _, err = os.Stat(path); err == nil {
+		if err = os.Rename(path, old); err != nil {
+			return err
+		}
+	}
+	if err = os.Rename(tmp, path); err != nil {
+		os.Rename(old, path)
+		return err
+	}
+	return os.RemoveAll(old)
+}
+
+// restoreCopy copy the backup to a new db in dir, checking every key is of emssdb
+func restoreCopy(bdb *leveldb.DB, dir string) (err error) {
+	o := &opt.Options{ErrorIfExist: true, Filter: filter.NewBloomFilter(10)}
+	ndb, err := leveldb.OpenFile(dir, o)
+	if err != nil {
+		return err
+	}
+	defer ndb.Close()
+
+	w := &bulkWriter{db: ndb}
+	it := bdb.NewIterator(nil, &opt.ReadOptions{DontFillCache: true})
+	defer it.Release()
+	for it.Next() {
+		if !knownPrefix(it.Key()) {
+			return ErrBadBackup
+		}
+		if err = w.put(it.Key(), it.Value()); err != nil {
+			return err
+		}
+	}
+	if err = it.Error(); err != nil {
+		return err
+	}
+	return w.flush()
+}
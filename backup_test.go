@@ -0,0 +1,105 @@
+package emssdb
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestBackupRestore(t *testing.T) {
+	d := openTestDB(t, Options{})
+	ns, _ := d.Namespace("ns")
+	fillTestData(d, "root")
+	fillTestData(ns, "ns")
+	dir := filepath.Join(t.TempDir(), "backup")
+	if err := d.Backup(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Backup(dir); err == nil {
+		t.Fatal("a backup over an existing one")
+	}
+
+	// the incremental backup writes the changes and deletes the removed keys
+	d.Del(Bytes("roota"))
+	ns.Hclear(Bytes("hns"))
+	fillTestData(ns, "more")
+	if err := d.BackupIncremental(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.BackupIncremental(filepath.Join(t.TempDir(), "none")); err == nil {
+		t.Fatal("an incremental backup without the first one")
+	}
+	want := dumpBytes(t, d)
+	b := openTestDB(t, Options{Path: dir})
+	if !bytes.Equal(dumpBytes(t, b), want) {
+		t.Fatal("the backup differs from the db")
+	}
+	checkClean(t, b)
+	b.Close()
+
+	path := filepath.Join(t.TempDir(), "db")
+	openTestDB(t, Options{Path: path}).Close()
+	if err := Restore(dir, path); err != nil {
+		t.Fatal(err)
+	}
+	r := openTestDB(t, Options{Path: path})
+	if !bytes.Equal(dumpBytes(t, r), want) {
+		t.Fatal("the restored db differs from the db")
+	}
+}
+
+func TestRestoreBadBackup(t *testing.T) {
+	dir := t.TempDir()
+	writeRawDB(t, dir, map[string]Bytes{"k": Bytes("v"), "?unknown": Bytes("v")})
+	path := filepath.Join(t.TempDir(), "db")
+	d, err := OpenDB(Options{Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Set(Bytes("k"), Bytes("kept"))
+	d.Close()
+
+	if err := Restore(dir, path); err != ErrBadBackup {
+		t.Fatal(err)
+	}
+	d = openTestDB(t, Options{Path: path})
+	if val, err := d.Get(Bytes("k")); err != nil || string(val) != "kept" {
+		t.Fatal(val, err)
+	}
+}
+
+// TestBackupWhileWriting check that a backup taken under writes is consistent
+func TestBackupWhileWriting(t *testing.T) {
+	d := openTestDB(t, Options{})
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			d.Update(func(tx *Tx) error {
+				tx.Hset(Bytes("h"), Bytes(fmt.Sprint(i)), Bytes("v"))
+				tx.QpushBack(Bytes("q"), Bytes("v"))
+				return tx.Zset(Bytes("z"), Bytes(fmt.Sprint(i%100)), int64(i))
+			})
+		}
+	}()
+	dir := filepath.Join(t.TempDir(), "backup")
+	err := d.Backup(dir)
+	for i := 0; err == nil && i < 3; i++ {
+		err = d.BackupIncremental(dir)
+	}
+	close(stop)
+	wg.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkClean(t, openTestDB(t, Options{Path: dir}))
+}
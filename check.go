@@ -0,0 +1,419 @@
+package emssdb
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	checkMaxIssues = 1000
+)
+
+// CheckIssue a mismatch found by Check. The kinds are
+//
+//	badkey:   a key which can not be decoded, Repair leaves it
+//	hsize:    the size of a hash is not the number of its fields
+//	zsize:    the size of a zset is not the number of its members
+//	zscore:   a member without its score entry, or a score entry without its member
+//	qsize:    the size of a queue is not the number of its items
+//	qpointer: the front or the back of a queue does not point to its first or last item
+//	qgap:     the seqs of the items of a queue are not contiguous
+//	exstamp:  an exkv without its expire stamp, or a stamp without its exkv
+type CheckIssue struct {
+	Kind string
+	Key  Bytes // the raw key
+	Msg  string
+}
+
+// CheckReport the result of Check and Repair
+type CheckReport struct {
+	Keys     int64        // keys scanned
+	Problems int64        // mismatches found
+	Issues   []CheckIssue // the first checkMaxIssues of the mismatches
+	Repaired int64        // mismatches fixed by Repair
+}
+
+// Check scan all the data types of a snapshot and report the mismatches
+// of the sizes, the zset and exkv indexes and the queue pointers.
+// The Check of a DB covers its namespaces, the Check of a namespace only the namespace.
+func (d *DB) Check() (ret *CheckReport, err error) {
+	defer d.metrics.observe("check", time.Now(), &err)
+	return d.check(false)
+}
+
+// Repair is Check which also rewrites the mismatches, the writers wait until it is done
+func (d *DB) Repair() (ret *CheckReport, err error) {
+	defer d.metrics.observe("repair", time.Now(), &err)
+	return d.check(true)
+}
+
+type checker struct {
+	d      *DB
+	v      view
+	repair bool
+	rep    CheckReport
+	tx     *Tx
+	fixes  int
+}
+
+func (d *DB) check(repair bool) (ret *CheckReport, err error) {
+	if repair {
+		if d.readOnly {
+			return nil, ErrReadOnly
+		}
+		d.writer.LockAll()
+		defer d.writer.UnlockAll()
+	}
+	snap, err := d.db.GetSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	defer snap.Release()
+
+	c := &checker{d: d, repair: repair}
+	if err = c.run(snap); err != nil || d.root != nil {
+		return &c.rep, err
+	}
+	// the Check of a DB checks its namespaces too
+	for _, name := range namespaceNames(snap) {
+		if c.d, err = d.Namespace(name); err != nil {
+			return &c.rep, err
+		}
+		if err = c.run(snap); err != nil {
+			return &c.rep, err
+		}
+	}
+	return &c.rep, nil
+}
+
+// run check the data types of c.d in snap
+func (c *checker) run(snap reader) (err error) {
+	c.v.r = c.d.prefixed(snap)
+	for _, fn := range []func() error{c.checkHash, c.checkZset, c.checkQueue, c.checkExkv} {
+		if err = fn(); err != nil {
+			return err
+		}
+	}
+	return c.flush()
+}
+
+// issue report a mismatch, and with repair fix it in a transaction touching dt/name
+func (c *checker) issue(kind string, key Bytes, msg string, dt byte, name Bytes, fix func(tx *Tx)) (err error) {
+	c.rep.Problems++
+	if len(c.rep.Issues) < checkMaxIssues {
+		c.rep.Issues = append(c.rep.Issues, CheckIssue{kind, c.d.rawKey(key), msg})
+	}
+	if !c.repair || fix == nil {
+		return nil
+	}
+	if c.tx == nil {
+		c.tx = newTx(c.d)
+	}
+	c.tx.touch(dt, name)
+	fix(c.tx)
+	c.rep.Repaired++
+	if c.fixes++; c.fixes >= loadBatch {
+		return c.flush()
+	}
+	return nil
+}
+
+func (c *checker) flush() (err error) {
+	if c.tx == nil {
+		return nil
+	}
+	tx := c.tx
+	c.tx, c.fixes = nil, 0
+	if err = tx.bumpVersions(); err != nil {
+		return err
+	}
+	return c.d.writer.Commit(&tx.batch)
+}
+
+// scan call fn with every key of the data type dt
+func (c *checker) scan(dt byte, fn func(key, val Bytes) error) (err error) {
+	it := c.v.Iterator(encodeOneKey(dt, nil), encodeOneKey(dt+1, nil))
+	defer it.Close()
+	for it.Next() {
+		c.rep.Keys++
+		if err = fn(it.Key(), it.Value()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *checker) has(key Bytes) bool {
+	_, err := c.v.r.Get(key, nil)
+	return err == nil
+}
+
+// sizes load the sizes of the containers, [sizeDT][name] => size
+func (c *checker) sizes(sizeDT byte) (ret map[string]int64, err error) {
+	ret = make(map[string]int64)
+	err = c.scan(sizeDT, func(key, val Bytes) error {
+		ret[string(key[1:])] = val.GetInt64()
+		return nil
+	})
+	return ret, err
+}
+
+// checkSize compare the size of a container with the number of its items, and forget it
+func (c *checker) checkSize(kind string, sizes map[string]int64, sizeDT, dt byte, name Bytes, count int64) (err error) {
+	size, ok := sizes[string(name)]
+	delete(sizes, string(name))
+	if ok && size == count {
+		return nil
+	}
+	skey := encodeOneKey(sizeDT, name)
+	return c.issue(kind, skey, fmt.Sprintf("size %d, %d items", size, count), dt, name, func(tx *Tx) {
+		tx.put(skey, NewByInt64(count))
+	})
+}
+
+// checkEmpty report the sizes left by checkSize, which have no items
+func (c *checker) checkEmpty(kind string, sizes map[string]int64, sizeDT, dt byte) (err error) {
+	names := make([]string, 0, len(sizes))
+	for name := range sizes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		skey := encodeOneKey(sizeDT, Bytes(name))
+		fix := func(tx *Tx) {
+			tx.delete(skey)
+		}
+		if err = c.issue(kind, skey, fmt.Sprintf("size %d, 0 items", sizes[name]), dt, Bytes(name), fix); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// countItems count the items of each container of dt, name is nil for a bad key
+func (c *checker) countItems(dt byte, decode func(key Bytes) (name Bytes), done func(name Bytes, count int64) error) (err error) {
+	var cur Bytes
+	var count int64
+	err = c.scan(dt, func(key, val Bytes) error {
+		name := decode(key)
+		if name == nil {
+			return c.issue("badkey", key, "undecodable key", 0, nil, nil)
+		}
+		if count > 0 && !bytes.Equal(name, cur) {
+			if err := done(cur, count); err != nil {
+				return err
+			}
+			count = 0
+		}
+		cur = name
+		count++
+		return nil
+	})
+	if err == nil && count > 0 {
+		err = done(cur, count)
+	}
+	return err
+}
+
+func (c *checker) checkHash() (err error) {
+	sizes, err := c.sizes(DTHSIZE)
+	if err != nil {
+		return err
+	}
+	err = c.countItems(DTHASH, func(key Bytes) Bytes {
+		name, _ := decodeHashKey(key)
+		return name
+	}, func(name Bytes, count int64) error {
+		return c.checkSize("hsize", sizes, DTHSIZE, DTHASH, name, count)
+	})
+	if err != nil {
+		return err
+	}
+	return c.checkEmpty("hsize", sizes, DTHSIZE, DTHASH)
+}
+
+func (c *checker) checkZset() (err error) {
+	sizes, err := c.sizes(DTZSIZE)
+	if err != nil {
+		return err
+	}
+	// the members, and their score entries
+	err = c.countItems(DTZSET, func(key Bytes) Bytes {
+		name, _ := decodeZsetKey(key)
+		return name
+	}, func(name Bytes, count int64) error {
+		return c.checkSize("zsize", sizes, DTZSIZE, DTZSET, name, count)
+	})
+	if err != nil {
+		return err
+	}
+	if err = c.checkEmpty("zsize", sizes, DTZSIZE, DTZSET); err != nil {
+		return err
+	}
+	err = c.scan(DTZSET, func(key, val Bytes) error {
+		name, zkey := decodeZsetKey(key)
+		if name == nil || len(val) != 8 {
+			return nil
+		}
+		skey := encodeZscoreKey(name, zkey, val.GetInt64())
+		if c.has(skey) {
+			return nil
+		}
+		return c.issue("zscore", key, "member without score entry", DTZSET, name, func(tx *Tx) {
+			tx.put(skey, nil)
+		})
+	})
+	if err != nil {
+		return err
+	}
+	// the score entries, and their members
+	return c.scan(DTZSCORE, func(key, val Bytes) error {
+		name, zkey, score := decodeZscoreKey(key)
+		if name == nil {
+			return c.issue("badkey", key, "undecodable key", 0, nil, nil)
+		}
+		if sval, err := c.v.r.Get(encodeZsetKey(name, zkey), nil); err == nil && Bytes(sval).GetInt64() == score {
+			return nil
+		}
+		return c.issue("zscore", key, "score entry without member", DTZSET, name, func(tx *Tx) {
+			tx.delete(key)
+		})
+	})
+}
+
+// queue is the state of a queue gathered by checkQueue
+type queue struct {
+	name        Bytes
+	count       int64
+	first, last int64 // the smallest and the largest seqs of the items
+	front, back int64 // the pointers, -1 if not found
+}
+
+func (c *checker) checkQueue() (err error) {
+	sizes, err := c.sizes(DTQSIZE)
+	if err != nil {
+		return err
+	}
+	var q *queue
+	err = c.scan(DTQUEUE, func(key, val Bytes) error {
+		name, seq := decodeQitemKey(key)
+		if name == nil {
+			return c.issue("badkey", key, "undecodable key", 0, nil, nil)
+		}
+		if q != nil && !bytes.Equal(name, q.name) {
+			if err := c.checkOneQueue(q, sizes); err != nil {
+				return err
+			}
+			q = nil
+		}
+		if q == nil {
+			q = &queue{name: name, first: -1, last: -1, front: -1, back: -1}
+		}
+		switch seq {
+		case qFRONT_SEQ:
+			q.front = val.GetInt64()
+		case qBACK_SEQ:
+			q.back = val.GetInt64()
+		default:
+			if q.count == 0 {
+				q.first = seq
+			}
+			q.last = seq
+			q.count++
+		}
+		return nil
+	})
+	if err == nil && q != nil {
+		err = c.checkOneQueue(q, sizes)
+	}
+	if err != nil {
+		return err
+	}
+	return c.checkEmpty("qsize", sizes, DTQSIZE, DTQUEUE)
+}
+
+// checkOneQueue check the items of a queue are contiguous from the back (the smallest seq)
+// to the front (the largest seq), and match its size and pointers
+func (c *checker) checkOneQueue(q *queue, sizes map[string]int64) (err error) {
+	name := q.name
+	if q.count == 0 {
+		// only the pointers are left
+		delete(sizes, string(name))
+		return c.issue("qpointer", encodeQitemKey(name, qFRONT_SEQ), "pointers of an empty queue", DTQUEUE, name, func(tx *Tx) {
+			tx.qdelOne(name, qFRONT_SEQ)
+			tx.qdelOne(name, qBACK_SEQ)
+			tx.qsetSize(name, 0)
+		})
+	}
+
+	if q.last-q.first+1 != q.count {
+		msg := fmt.Sprintf("%d items in seqs %d to %d", q.count, q.first, q.last)
+		err = c.issue("qgap", encodeQitemKey(name, q.first), msg, DTQUEUE, name, func(tx *Tx) {
+			c.renumberQueue(tx, q)
+		})
+		if err != nil {
+			return err
+		}
+		q.last = q.first + q.count - 1
+	}
+	if q.front != q.last || q.back != q.first {
+		msg := fmt.Sprintf("front %d back %d, items in seqs %d to %d", q.front, q.back, q.first, q.last)
+		err = c.issue("qpointer", encodeQitemKey(name, qFRONT_SEQ), msg, DTQUEUE, name, func(tx *Tx) {
+			tx.qsetInt(name, qFRONT_SEQ, q.last)
+			tx.qsetInt(name, qBACK_SEQ, q.first)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return c.checkSize("qsize", sizes, DTQSIZE, DTQUEUE, name, q.count)
+}
+
+// renumberQueue move the items of the queue to the contiguous seqs from q.first, in order
+func (c *checker) renumberQueue(tx *Tx, q *queue) {
+	it := c.v.Iterator(encodeQitemKey(q.name, q.first), encodeQitemKey(q.name, q.last+1))
+	defer it.Close()
+	for seq := q.first; it.Next(); seq++ {
+		_, old := decodeQitemKey(it.Key())
+		if old != seq {
+			tx.qdelOne(q.name, old)
+			tx.qsetOne(q.name, seq, it.Value())
+		}
+	}
+}
+
+func (c *checker) checkExkv() (err error) {
+	err = c.scan(DTEXKV, func(key, val Bytes) error {
+		if len(val) < 8 {
+			return c.issue("badkey", key, "exkv value without expire time", 0, nil, nil)
+		}
+		ekey := key[1:]
+		_, etime := decodeExkvValue(val)
+		xkey := encodeExstampKey(ekey, etime)
+		if c.has(xkey) {
+			return nil
+		}
+		return c.issue("exstamp", key, "exkv without expire stamp", DTEXKV, ekey, func(tx *Tx) {
+			tx.put(xkey, nil)
+		})
+	})
+	if err != nil {
+		return err
+	}
+	return c.scan(DTEXSTAMP, func(key, val Bytes) error {
+		if len(key) < 9 {
+			return c.issue("badkey", key, "undecodable key", 0, nil, nil)
+		}
+		ekey, etime := decodeExstampKey(key)
+		if eval, err := c.v.r.Get(encodeExkvKey(ekey), nil); err == nil && len(eval) >= 8 {
+			if _, e := decodeExkvValue(eval); e == etime {
+				return nil
+			}
+		}
+		return c.issue("exstamp", key, "expire stamp without exkv", DTEXKV, ekey, func(tx *Tx) {
+			tx.delete(key)
+		})
+	})
+}
@@ -0,0 +1,36 @@
+package emssdb
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestClear(t *testing.T) {
+	d := openTestDB(t, Options{})
+	for _, name := range []string{"a", "b"} {
+		for i := 0; i < 2*loadBatch+500; i++ {
+			k := Bytes(fmt.Sprint(i))
+			d.Hset(Bytes(name), k, k)
+			d.Zset(Bytes(name), k, int64(i%7))
+			d.QpushBack(Bytes(name), k)
+		}
+	}
+	for _, clear := range []func(Bytes) (int64, error){d.Hclear, d.Zclear, d.Qclear} {
+		if n, err := clear(Bytes("a")); n != 2*loadBatch+500 || err != nil {
+			t.Fatal(n, err)
+		}
+		if n, err := clear(Bytes("a")); n != 0 || err != nil {
+			t.Fatal(n, err)
+		}
+	}
+	if d.Exists(Bytes("a")) {
+		t.Fatalf("a left as %q", d.Type(Bytes("a")))
+	}
+	hsize, _ := d.Hsize(Bytes("b"))
+	zsize, _ := d.Zsize(Bytes("b"))
+	qsize, _ := d.Qsize(Bytes("b"))
+	if hsize != 2*loadBatch+500 || zsize != hsize || qsize != hsize {
+		t.Fatal(hsize, zsize, qsize)
+	}
+	checkClean(t, d)
+}
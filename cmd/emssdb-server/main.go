@@ -0,0 +1,120 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/neverlee/emssdb"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+var (
+	dbPath      = flag.String("path", "./var/data", "database directory")
+	ssdbAddr    = flag.String("addr", "127.0.0.1:8888", "listen address of the ssdb protocol")
+	respAddr    = flag.String("resp", "", "listen address of the redis protocol, empty to disable")
+	cacheSize   = flag.Int("cache", 8, "block cache size in MB")
+	compression = flag.Bool("compression", true, "enable snappy compression")
+	expireDelay = flag.Duration("expire-delay", time.Second, "interval of the expire daemon")
+	syncPolicy  = flag.String("sync", "none", "fsync policy: none, always or interval")
+	syncEvery   = flag.Duration("sync-interval", time.Second, "fsync interval of -sync interval")
+	groupCommit = flag.Bool("group-commit", false, "coalesce the commits of concurrent clients")
+	readOnly    = flag.Bool("readonly", false, "serve the data read only, without expiring keys")
+	recoverDB   = flag.Bool("recover", false, "rebuild a corrupted db before serving it")
+	metricsAddr = flag.String("metrics", "", "listen address of the prometheus metrics on /metrics, empty to disable")
+)
+
+func main() {
+	flag.Parse()
+
+	opt := emssdb.Options{
+		Path:        *dbPath,
+		CacheSize:   *cacheSize,
+		Compression: *compression,
+		ExpireDelay: *expireDelay,
+
+		SyncInterval: *syncEvery,
+		GroupCommit:  *groupCommit,
+		ReadOnly:     *readOnly,
+		Metrics:      *metricsAddr != "",
+	}
+	switch *syncPolicy {
+	case "none":
+		opt.Sync = emssdb.SyncNone
+	case "always":
+		opt.Sync = emssdb.SyncEveryWrite
+	case "interval":
+		opt.Sync = emssdb.SyncByInterval
+	default:
+		fmt.Fprintln(os.Stderr, "unknown sync policy:", *syncPolicy)
+		os.Exit(2)
+	}
+	var db *emssdb.DB
+	var err error
+	if *recoverDB {
+		var rep *emssdb.RecoverReport
+		if db, rep, err = emssdb.RecoverDB(opt); err == nil {
+			fmt.Printf("recovered %d keys, deleted %d unknown keys, repaired %d mismatches\n",
+				rep.Keys, rep.Unknown, rep.Check.Repaired)
+			for _, issue := range rep.Check.Issues {
+				fmt.Printf("  %s %q: %s\n", issue.Kind, issue.Key, issue.Msg)
+			}
+		}
+	} else {
+		db, err = emssdb.OpenDB(opt)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "open db:", err)
+		os.Exit(1)
+	}
+
+	ln, err := net.Listen("tcp", *ssdbAddr)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "listen:", err)
+		db.Close()
+		os.Exit(1)
+	}
+	fmt.Println("ssdb protocol listen on", ln.Addr())
+	server := NewSSDBServer(db)
+	go server.Serve(ln)
+
+	var rln net.Listener
+	var rserver *RESPServer
+	if *respAddr != "" {
+		if rln, err = net.Listen("tcp", *respAddr); err != nil {
+			fmt.Fprintln(os.Stderr, "listen:", err)
+			ln.Close()
+			server.Close()
+			db.Close()
+			os.Exit(1)
+		}
+		fmt.Println("redis protocol listen on", rln.Addr())
+		rserver = NewRESPServer(db)
+		go rserver.Serve(rln)
+	}
+
+	if *metricsAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", db.MetricsHandler())
+		fmt.Println("metrics listen on", *metricsAddr)
+		go func() {
+			if err := http.ListenAndServe(*metricsAddr, mux); err != nil {
+				fmt.Fprintln(os.Stderr, "metrics:", err)
+			}
+		}()
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	<-sig
+	ln.Close()
+	server.Close()
+	if rserver != nil {
+		rln.Close()
+		rserver.Close()
+	}
+	db.Close()
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bufio"
+	"github.com/neverlee/emssdb"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	respMaxBulk  = 512 * 1024 * 1024
+	respMaxArray = 1024 * 1024
+)
+
+// RESPServer serve a emssdb by the redis protocol (RESP2 and RESP3)
+type RESPServer struct {
+	db    *emssdb.DB
+	mutex sync.Mutex
+	conns map[net.Conn]bool
+	wait  sync.WaitGroup
+}
+
+// NewRESPServer return a redis protocol server of db
+func NewRESPServer(db *emssdb.DB) *RESPServer {
+	var s RESPServer
+	s.db = db
+	s.conns = make(map[net.Conn]bool)
+	return &s
+}
+
+// Serve accept connections from ln until it is closed
+func (s *RESPServer) Serve(ln net.Listener) (err error) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		s.mutex.Lock()
+		s.conns[conn] = true
+		s.mutex.Unlock()
+		s.wait.Add(1)
+		go s.handle(conn)
+	}
+}
+
+// Close close all client connections and wait for the handlers
+func (s *RESPServer) Close() {
+	s.mutex.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mutex.Unlock()
+	s.wait.Wait()
+}
+
+// respConn is the state of one client
+type respConn struct {
+	root  *emssdb.DB
+	db    *emssdb.DB // the root, or the namespace chosen by SELECT
+	w     *bufio.Writer
+	proto int
+	quit  bool
+}
+
+func (s *RESPServer) handle(conn net.Conn) {
+	defer func() {
+		s.mutex.Lock()
+		delete(s.conns, conn)
+		s.mutex.Unlock()
+		conn.Close()
+		s.wait.Done()
+	}()
+
+	reader := bufio.NewReader(conn)
+	c := &respConn{root: s.db, db: s.db, w: bufio.NewWriter(conn), proto: 2}
+	for !c.quit {
+		req, err := readRESPRequest(reader)
+		if err != nil {
+			if err != io.EOF {
+				c.error("ERR Protocol error: " + err.Error())
+				c.w.Flush()
+			}
+			return
+		}
+		if len(req) == 0 {
+			continue
+		}
+		c.do(req)
+		if reader.Buffered() == 0 || c.quit {
+			if err := c.w.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (c *respConn) do(req [][]byte) {
+	cmd := strings.ToLower(string(req[0]))
+	cmdInfo, ok := respCommands[cmd]
+	if !ok {
+		c.error("ERR unknown command '" + cmd + "'")
+		return
+	}
+	args := req[1:]
+	if len(args) < cmdInfo.min || (cmdInfo.max >= 0 && len(args) > cmdInfo.max) {
+		c.error("ERR wrong number of arguments for '" + cmd + "' command")
+		return
+	}
+	cmdInfo.handler(c, cmd, args)
+}
+
+// readRESPRequest read an array of bulk strings or an inline command
+func readRESPRequest(reader *bufio.Reader) (req [][]byte, err error) {
+	line, err := readRESPLine(reader)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 || line[0] != '*' {
+		for _, field := range strings.Fields(string(line)) {
+			req = append(req, []byte(field))
+		}
+		return req, nil
+	}
+
+	n, err := strconv.Atoi(string(line[1:]))
+	if err != nil || n > respMaxArray {
+		return nil, errBadPacket
+	}
+	for i := 0; i < n; i++ {
+		line, err := readRESPLine(reader)
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return nil, errBadPacket
+		}
+		size, err := strconv.Atoi(string(line[1:]))
+		if err != nil || size < 0 || size > respMaxBulk {
+			return nil, errBadPacket
+		}
+		bulk := make([]byte, size+2)
+		if _, err := io.ReadFull(reader, bulk); err != nil {
+			return nil, err
+		}
+		if bulk[size] != '\r' || bulk[size+1] != '\n' {
+			return nil, errBadPacket
+		}
+		req = append(req, bulk[:size])
+	}
+	return req, nil
+}
+
+func readRESPLine(reader *bufio.Reader) (line []byte, err error) {
+	line, err = reader.ReadSlice('\n')
+	if err != nil {
+		if err == io.EOF && len(line) > 0 {
+			err = errBadPacket
+		}
+		return nil, err
+	}
+	return []byte(strings.TrimRight(string(line), "\r\n")), nil
+}
+
+func (c *respConn) status(s string) {
+	c.w.WriteString("+" + s + "\r\n")
+}
+
+func (c *respConn) error(msg string) {
+	c.w.WriteString("-" + msg + "\r\n")
+}
+
+func (c *respConn) integer(i int64) {
+	c.w.WriteString(":" + strconv.FormatInt(i, 10) + "\r\n")
+}
+
+func (c *respConn) bulk(b []byte) {
+	c.w.WriteString("$" + strconv.Itoa(len(b)) + "\r\n")
+	c.w.Write(b)
+	c.w.WriteString("\r\n")
+}
+
+func (c *respConn) null() {
+	if c.proto >= 3 {
+		c.w.WriteString("_\r\n")
+	} else {
+		c.w.WriteString("$-1\r\n")
+	}
+}
+
+func (c *respConn) array(n int) {
+	c.w.WriteString("*" + strconv.Itoa(n) + "\r\n")
+}
+
+// mapHeader write a RESP3 map, or a flat array of 2n items in RESP2
+func (c *respConn) mapHeader(n int) {
+	if c.proto >= 3 {
+		c.w.WriteString("%" + strconv.Itoa(n) + "\r\n")
+	} else {
+		c.array(2 * n)
+	}
+}
+
+// score write a zset score, which is a double in RESP3
+func (c *respConn) score(i int64) {
+	if c.proto >= 3 {
+		c.w.WriteString("," + strconv.FormatInt(i, 10) + "\r\n")
+	} else {
+		c.bulk(itob(i))
+	}
+}
+
+// dbError reply the errors of emssdb
+func (c *respConn) dbError(err error) {
+	switch err {
+	case emssdb.ErrNotFound:
+		c.null()
+	case emssdb.ErrEmptyKey:
+		c.error("ERR empty key")
+	case emssdb.ErrLongKey:
+		c.error("ERR key too long")
+	case emssdb.ErrNotIntVal:
+		c.error("ERR value is not an integer or out of range")
+	case emssdb.ErrOutOfRange:
+		c.error("ERR out of range")
+	case emssdb.ErrReadOnly:
+		c.error("READONLY the database is opened read only")
+	case emssdb.ErrKeyExists:
+		c.error("ERR target key name already exists")
+	default:
+		c.error("ERR " + err.Error())
+	}
+}
@@ -0,0 +1,836 @@
+package main
+
+import (
+	"bytes"
+	"github.com/neverlee/emssdb"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type respHandler func(c *respConn, cmd string, args [][]byte)
+
+// respCommand is a handler with its min and max argument count, max -1 means no limit
+type respCommand struct {
+	handler respHandler
+	min     int
+	max     int
+}
+
+var respCommands = map[string]respCommand{
+	"ping":    {respPing, 0, 1},
+	"echo":    {respEcho, 1, 1},
+	"hello":   {respHello, 0, -1},
+	"select":  {respSelect, 1, 1},
+	"command": {respCommandCmd, 0, -1},
+	"quit":    {respQuit, 0, 0},
+	"info":    {respInfo, 0, -1},
+	// kv
+	"get":      {respGet, 1, 1},
+	"set":      {respSet, 2, 4},
+	"setex":    {respSetex, 3, 3},
+	"del":      {respDel, 1, -1},
+	"exists":   {respExists, 1, -1},
+	"type":     {respType, 1, 1},
+	"rename":   {respRename, 2, 2},
+	"renamenx": {respRename, 2, 2},
+	"incr":     {respIncr, 1, 1},
+	"decr":     {respIncr, 1, 1},
+	"incrby":   {respIncr, 2, 2},
+	"decrby":   {respIncr, 2, 2},
+	"mget":     {respMget, 1, -1},
+	"mset":     {respMset, 2, -1},
+	"expire":   {respExpire, 2, 2},
+	"expireat": {respExpire, 2, 2},
+	"ttl":      {respTTL, 1, 1},
+	"persist":  {respPersist, 1, 1},
+	// hash
+	"hset":    {respHset, 3, -1},
+	"hget":    {respHget, 2, 2},
+	"hdel":    {respHdel, 2, -1},
+	"hincrby": {respHincrby, 3, 3},
+	"hlen":    {respHlen, 1, 1},
+	"hexists": {respHexists, 2, 2},
+	"hgetall": {respHgetall, 1, 1},
+	"hkeys":   {respHgetall, 1, 1},
+	"hvals":   {respHgetall, 1, 1},
+	// zset
+	"zadd":             {respZadd, 3, -1},
+	"zscore":           {respZscore, 2, 2},
+	"zincrby":          {respZincrby, 3, 3},
+	"zrem":             {respZrem, 2, -1},
+	"zcard":            {respZcard, 1, 1},
+	"zrangebyscore":    {respZrangebyscore, 3, 7},
+	"zrevrangebyscore": {respZrangebyscore, 3, 7},
+	// list, on the emssdb queue: the head of the list is the front of the queue
+	"lpush": {respPush, 2, -1},
+	"rpush": {respPush, 2, -1},
+	"lpop":  {respPop, 1, 2},
+	"rpop":  {respPop, 1, 2},
+	"llen":  {respLlen, 1, 1},
+}
+
+func parseInt(b []byte) (i int64, ok bool) {
+	i, err := btoi(b)
+	return i, err == nil
+}
+
+// parseRESPScore accept integral scores only, as the emssdb scores are int64
+func parseRESPScore(b []byte) (score int64, ok bool) {
+	if score, ok := parseInt(b); ok {
+		return score, true
+	}
+	f, err := strconv.ParseFloat(string(b), 64)
+	if err != nil || f != math.Trunc(f) || f < math.MinInt64 || f > math.MaxInt64 {
+		return 0, false
+	}
+	return int64(f), true
+}
+
+// parseRESPRange parse min/max of zrangebyscore into a closed range,
+// -inf, +inf and the exclusive (score are supported
+func parseRESPRange(b []byte, isMin bool) (score int64, ok bool) {
+	s := strings.ToLower(string(b))
+	switch s {
+	case "-inf":
+		return math.MinInt64, true
+	case "+inf", "inf":
+		return math.MaxInt64, true
+	}
+	exclusive := strings.HasPrefix(s, "(")
+	if exclusive {
+		s = s[1:]
+	}
+	score, ok = parseRESPScore([]byte(s))
+	if ok && exclusive {
+		if isMin && score < math.MaxInt64 {
+			score++
+		} else if !isMin && score > math.MinInt64 {
+			score--
+		}
+	}
+	return score, ok
+}
+
+func respPing(c *respConn, cmd string, args [][]byte) {
+	if len(args) == 1 {
+		c.bulk(args[0])
+	} else {
+		c.status("PONG")
+	}
+}
+
+func respEcho(c *respConn, cmd string, args [][]byte) {
+	c.bulk(args[0])
+}
+
+// hello [protover [AUTH username password] [SETNAME clientname]]
+func respHello(c *respConn, cmd string, args [][]byte) {
+	if len(args) > 0 {
+		proto, ok := parseInt(args[0])
+		if !ok || proto < 2 || proto > 3 {
+			c.error("NOPROTO unsupported protocol version")
+			return
+		}
+		c.proto = int(proto)
+	}
+	c.mapHeader(6)
+	c.bulk([]byte("server"))
+	c.bulk([]byte("emssdb"))
+	c.bulk([]byte("version"))
+	c.bulk([]byte("1.0.0"))
+	c.bulk([]byte("proto"))
+	c.integer(int64(c.proto))
+	c.bulk([]byte("mode"))
+	c.bulk([]byte("standalone"))
+	c.bulk([]byte("role"))
+	c.bulk([]byte("master"))
+	c.bulk([]byte("modules"))
+	c.array(0)
+}
+
+// select index, the index 0 is the db, the others are its namespaces named by the index
+func respSelect(c *respConn, cmd string, args [][]byte) {
+	index, ok := parseInt(args[0])
+	if !ok || index < 0 {
+		c.error("ERR DB index is out of range")
+		return
+	}
+	if index == 0 {
+		c.db = c.root
+	} else {
+		ns, err := c.root.Namespace(strconv.FormatInt(index, 10))
+		if err != nil {
+			c.dbError(err)
+			return
+		}
+		c.db = ns
+	}
+	c.status("OK")
+}
+
+// command is asked by redis-cli on connect, there is no command docs
+func respCommandCmd(c *respConn, cmd string, args [][]byte) {
+	c.array(0)
+}
+
+func respQuit(c *respConn, cmd string, args [][]byte) {
+	c.status("OK")
+	c.quit = true
+}
+
+func respInfo(c *respConn, cmd string, args [][]byte) {
+	info := "# Emssdb\r\n"
+	for key, val := range c.db.Info() {
+		info += key + ":" + val + "\r\n"
+	}
+	c.bulk([]byte(info))
+}
+
+/***** KV *****/
+
+func respGet(c *respConn, cmd string, args [][]byte) {
+	val, err := getAny(c.db, args[0])
+	if err != nil {
+		c.dbError(err)
+		return
+	}
+	c.bulk(val)
+}
+
+// set key value [EX seconds|PX milliseconds|EXAT timestamp|PXAT milliseconds-timestamp]
+func respSet(c *respConn, cmd string, args [][]byte) {
+	var etime int64
+	if len(args) == 4 {
+		n, ok := parseInt(args[3])
+		if !ok || n <= 0 {
+			c.error("ERR invalid expire time in 'set' command")
+			return
+		}
+		now := time.Now()
+		switch strings.ToLower(string(args[2])) {
+		case "ex":
+			etime = now.Unix() + n
+		case "px":
+			etime = now.Add(time.Duration(n) * time.Millisecond).Unix()
+		case "exat":
+			etime = n
+		case "pxat":
+			etime = n / 1000
+		default:
+			c.error("ERR syntax error")
+			return
+		}
+	} else if len(args) != 2 {
+		c.error("ERR syntax error")
+		return
+	}
+
+	// the key is in one of the kv and the exkv spaces
+	err := c.db.Update(func(tx *emssdb.Tx) error {
+		if etime > 0 {
+			if err := tx.Eset(args[0], args[1], uint64(etime)); err != nil {
+				return err
+			}
+			return tx.Del(args[0])
+		}
+		if err := tx.Set(args[0], args[1]); err != nil {
+			return err
+		}
+		return tx.Edel(args[0])
+	})
+	if err != nil {
+		c.dbError(err)
+		return
+	}
+	c.status("OK")
+}
+
+func respSetex(c *respConn, cmd string, args [][]byte) {
+	respSet(c, "set", [][]byte{args[0], args[2], []byte("ex"), args[1]})
+}
+
+func respDel(c *respConn, cmd string, args [][]byte) {
+	var n int64
+	err := c.db.Update(func(tx *emssdb.Tx) error {
+		n = 0
+		for _, key := range args {
+			if _, err := getAny(tx, key); err == nil {
+				n++
+			}
+			if err := tx.Del(key); err != nil {
+				return err
+			}
+			if err := tx.Edel(key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		c.dbError(err)
+		return
+	}
+	c.integer(n)
+}
+
+func respExists(c *respConn, cmd string, args [][]byte) {
+	var n int64
+	for _, key := range args {
+		if _, err := getAny(c.db, key); err == nil {
+			n++
+		}
+	}
+	c.integer(n)
+}
+
+// type reply the first data type of the key, the strings are the kv and the exkv not expired
+func respType(c *respConn, cmd string, args [][]byte) {
+	if _, err := getAny(c.db, args[0]); err == nil {
+		c.status("string")
+		return
+	}
+	for _, dt := range c.db.Type(args[0]) {
+		switch dt {
+		case emssdb.DTHASH:
+			c.status("hash")
+			return
+		case emssdb.DTZSET:
+			c.status("zset")
+			return
+		case emssdb.DTQUEUE:
+			c.status("list")
+			return
+		}
+	}
+	c.status("none")
+}
+
+// respRename rename overwrites the destination of any type, renamenx refuses it
+func respRename(c *respConn, cmd string, args [][]byte) {
+	var err error
+	if cmd == "renamenx" {
+		err = c.db.Rename(args[0], args[1])
+	} else {
+		err = c.db.Update(func(tx *emssdb.Tx) error {
+			if !tx.Exists(args[0]) {
+				return emssdb.ErrNotFound
+			}
+			if bytes.Equal(args[0], args[1]) {
+				return nil
+			}
+			if _, err := tx.DeleteAny(args[1]); err != nil {
+				return err
+			}
+			return tx.Rename(args[0], args[1])
+		})
+	}
+	switch {
+	case err == nil && cmd == "renamenx":
+		c.integer(1)
+	case err == nil:
+		c.status("OK")
+	case err == emssdb.ErrKeyExists && cmd == "renamenx":
+		c.integer(0)
+	case err == emssdb.ErrNotFound:
+		c.error("ERR no such key")
+	default:
+		c.dbError(err)
+	}
+}
+
+func respIncr(c *respConn, cmd string, args [][]byte) {
+	by := int64(1)
+	if len(args) == 2 {
+		var ok bool
+		if by, ok = parseInt(args[1]); !ok {
+			c.error("ERR value is not an integer or out of range")
+			return
+		}
+	}
+	if cmd == "decr" || cmd == "decrby" {
+		by = -by
+	}
+	val, err := c.db.Incr(args[0], by)
+	if err != nil {
+		c.dbError(err)
+		return
+	}
+	c.integer(val)
+}
+
+func respMget(c *respConn, cmd string, args [][]byte) {
+	c.array(len(args))
+	for _, key := range args {
+		if val, err := getAny(c.db, key); err == nil {
+			c.bulk(val)
+		} else {
+			c.null()
+		}
+	}
+}
+
+func respMset(c *respConn, cmd string, args [][]byte) {
+	if len(args)%2 != 0 {
+		c.error("ERR wrong number of arguments for 'mset' command")
+		return
+	}
+	var keys, vals []emssdb.Bytes
+	for i := 0; i < len(args); i += 2 {
+		keys = append(keys, args[i])
+		vals = append(vals, args[i+1])
+	}
+	err := c.db.Update(func(tx *emssdb.Tx) error {
+		if err := tx.MultiSet(keys, vals); err != nil {
+			return err
+		}
+		for _, key := range keys {
+			if err := tx.Edel(key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		c.dbError(err)
+		return
+	}
+	c.status("OK")
+}
+
+// expire key seconds, expireat key timestamp
+func respExpire(c *respConn, cmd string, args [][]byte) {
+	n, ok := parseInt(args[1])
+	if !ok {
+		c.error("ERR value is not an integer or out of range")
+		return
+	}
+	etime := n
+	if cmd == "expire" {
+		etime += time.Now().Unix()
+	}
+	err := c.db.Update(func(tx *emssdb.Tx) error {
+		val, err := getAny(tx, args[0])
+		if err != nil {
+			return err
+		}
+		if err = tx.Del(args[0]); err != nil {
+			return err
+		}
+		if etime <= time.Now().Unix() {
+			return tx.Edel(args[0])
+		}
+		return tx.Eset(args[0], val, uint64(etime))
+	})
+	if err == emssdb.ErrNotFound {
+		c.integer(0)
+	} else if err != nil {
+		c.dbError(err)
+	} else {
+		c.integer(1)
+	}
+}
+
+func respTTL(c *respConn, cmd string, args [][]byte) {
+	if _, err := c.db.Get(args[0]); err == nil {
+		c.integer(-1)
+		return
+	}
+	_, etime, err := c.db.Eget(args[0])
+	if err != nil {
+		c.dbError(err)
+		return
+	}
+	now := uint64(time.Now().Unix())
+	if etime <= now {
+		c.integer(-2)
+		return
+	}
+	c.integer(int64(etime - now))
+}
+
+func respPersist(c *respConn, cmd string, args [][]byte) {
+	persisted := false
+	err := c.db.Update(func(tx *emssdb.Tx) error {
+		val, etime, err := tx.Eget(args[0])
+		if err != nil || etime <= uint64(time.Now().Unix()) {
+			return err
+		}
+		if err = tx.Set(args[0], val); err != nil {
+			return err
+		}
+		persisted = true
+		return tx.Edel(args[0])
+	})
+	if err != nil {
+		c.dbError(err)
+	} else if persisted {
+		c.integer(1)
+	} else {
+		c.integer(0)
+	}
+}
+
+/***** HASH *****/
+
+// hset key field value [field value ...], reply the number of new fields
+func respHset(c *respConn, cmd string, args [][]byte) {
+	if len(args)%2 != 1 {
+		c.error("ERR wrong number of arguments for 'hset' command")
+		return
+	}
+	var n int64
+	err := c.db.Update(func(tx *emssdb.Tx) error {
+		n = 0
+		for i := 1; i < len(args); i += 2 {
+			if _, err := tx.Hget(args[0], args[i]); err == emssdb.ErrNotFound {
+				n++
+			}
+			if err := tx.Hset(args[0], args[i], args[i+1]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		c.dbError(err)
+		return
+	}
+	c.integer(n)
+}
+
+func respHget(c *respConn, cmd string, args [][]byte) {
+	val, err := c.db.Hget(args[0], args[1])
+	if err != nil {
+		c.dbError(err)
+		return
+	}
+	c.bulk(val)
+}
+
+func respHdel(c *respConn, cmd string, args [][]byte) {
+	var n int64
+	err := c.db.Update(func(tx *emssdb.Tx) error {
+		n = 0
+		for _, field := range args[1:] {
+			if _, err := tx.Hget(args[0], field); err != nil {
+				continue
+			}
+			if err := tx.Hdel(args[0], field); err != nil {
+				return err
+			}
+			n++
+		}
+		return nil
+	})
+	if err != nil {
+		c.dbError(err)
+		return
+	}
+	c.integer(n)
+}
+
+func respHincrby(c *respConn, cmd string, args [][]byte) {
+	by, ok := parseInt(args[2])
+	if !ok {
+		c.error("ERR value is not an integer or out of range")
+		return
+	}
+	val, err := c.db.Hincr(args[0], args[1], by)
+	if err != nil {
+		c.dbError(err)
+		return
+	}
+	c.integer(val)
+}
+
+func respHlen(c *respConn, cmd string, args [][]byte) {
+	size, err := c.db.Hsize(args[0])
+	if err != nil && err != emssdb.ErrNotFound {
+		c.dbError(err)
+		return
+	}
+	c.integer(size)
+}
+
+func respHexists(c *respConn, cmd string, args [][]byte) {
+	if _, err := c.db.Hget(args[0], args[1]); err == nil {
+		c.integer(1)
+	} else if err == emssdb.ErrNotFound {
+		c.integer(0)
+	} else {
+		c.dbError(err)
+	}
+}
+
+// hgetall, hkeys and hvals
+func respHgetall(c *respConn, cmd string, args [][]byte) {
+	hit := c.db.Hscan(args[0], nil, nil)
+	defer hit.Close()
+	var items [][]byte
+	for hit.Next() {
+		if cmd != "hvals" {
+			items = append(items, hit.Key())
+		}
+		if cmd != "hkeys" {
+			items = append(items, hit.Value())
+		}
+	}
+	if cmd == "hgetall" {
+		c.mapHeader(len(items) / 2)
+	} else {
+		c.array(len(items))
+	}
+	for _, item := range items {
+		c.bulk(item)
+	}
+}
+
+/***** ZSET *****/
+
+// zadd key score member [score member ...], reply the number of new members
+func respZadd(c *respConn, cmd string, args [][]byte) {
+	if len(args)%2 != 1 {
+		c.error("ERR syntax error")
+		return
+	}
+	scores := make([]int64, 0, len(args)/2)
+	for i := 1; i < len(args); i += 2 {
+		score, ok := parseRESPScore(args[i])
+		if !ok {
+			c.error("ERR value is not an integer or out of range")
+			return
+		}
+		scores = append(scores, score)
+	}
+	var n int64
+	err := c.db.Update(func(tx *emssdb.Tx) error {
+		n = 0
+		for i, score := range scores {
+			member := args[2+2*i]
+			if _, err := tx.Zget(args[0], member); err == emssdb.ErrNotFound {
+				n++
+			}
+			if err := tx.Zset(args[0], member, score); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		c.dbError(err)
+		return
+	}
+	c.integer(n)
+}
+
+func respZscore(c *respConn, cmd string, args [][]byte) {
+	score, err := c.db.Zget(args[0], args[1])
+	if err != nil {
+		c.dbError(err)
+		return
+	}
+	c.score(score)
+}
+
+func respZincrby(c *respConn, cmd string, args [][]byte) {
+	by, ok := parseRESPScore(args[1])
+	if !ok {
+		c.error("ERR value is not an integer or out of range")
+		return
+	}
+	score, err := c.db.Zincr(args[0], args[2], by)
+	if err != nil {
+		c.dbError(err)
+		return
+	}
+	c.score(score)
+}
+
+func respZrem(c *respConn, cmd string, args [][]byte) {
+	var n int64
+	err := c.db.Update(func(tx *emssdb.Tx) error {
+		n = 0
+		for _, member := range args[1:] {
+			if _, err := tx.Zget(args[0], member); err != nil {
+				continue
+			}
+			if err := tx.Zdel(args[0], member); err != nil {
+				return err
+			}
+			n++
+		}
+		return nil
+	})
+	if err != nil {
+		c.dbError(err)
+		return
+	}
+	c.integer(n)
+}
+
+func respZcard(c *respConn, cmd string, args [][]byte) {
+	size, err := c.db.Zsize(args[0])
+	if err != nil && err != emssdb.ErrNotFound {
+		c.dbError(err)
+		return
+	}
+	c.integer(size)
+}
+
+// zrangebyscore key min max [WITHSCORES] [LIMIT offset count]
+// zrevrangebyscore key max min [WITHSCORES] [LIMIT offset count]
+func respZrangebyscore(c *respConn, cmd string, args [][]byte) {
+	reverse := cmd == "zrevrangebyscore"
+	minArg, maxArg := args[1], args[2]
+	if reverse {
+		minArg, maxArg = maxArg, minArg
+	}
+	min, ok1 := parseRESPRange(minArg, true)
+	max, ok2 := parseRESPRange(maxArg, false)
+	if !ok1 || !ok2 {
+		c.error("ERR min or max is not a float")
+		return
+	}
+
+	withScores := false
+	offset, count := int64(0), int64(-1)
+	for i := 3; i < len(args); i++ {
+		switch strings.ToLower(string(args[i])) {
+		case "withscores":
+			withScores = true
+		case "limit":
+			if i+2 >= len(args) {
+				c.error("ERR syntax error")
+				return
+			}
+			var ok1, ok2 bool
+			offset, ok1 = parseInt(args[i+1])
+			count, ok2 = parseInt(args[i+2])
+			if !ok1 || !ok2 {
+				c.error("ERR value is not an integer or out of range")
+				return
+			}
+			i += 2
+		default:
+			c.error("ERR syntax error")
+			return
+		}
+	}
+
+	type member struct {
+		key   []byte
+		score int64
+	}
+	var members []member
+	if min <= max && offset >= 0 {
+		// the scans exclude the upper score
+		if max < math.MaxInt64 {
+			max++
+		}
+		var zit *emssdb.ZIterator
+		if reverse {
+			zit = c.db.Zrscan(args[0], min, max)
+		} else {
+			zit = c.db.Zscan(args[0], min, max)
+		}
+		zit.Skip(uint64(offset))
+		for count != 0 && zit.Next() {
+			members = append(members, member{zit.Key(), zit.Score()})
+			count--
+		}
+		zit.Close()
+	}
+
+	if withScores && c.proto < 3 {
+		c.array(2 * len(members))
+	} else {
+		c.array(len(members))
+	}
+	for _, m := range members {
+		if withScores && c.proto >= 3 {
+			c.array(2)
+		}
+		c.bulk(m.key)
+		if withScores {
+			c.score(m.score)
+		}
+	}
+}
+
+/***** LIST *****/
+
+func respPush(c *respConn, cmd string, args [][]byte) {
+	push := c.db.QpushFront
+	if cmd == "rpush" {
+		push = c.db.QpushBack
+	}
+	for _, item := range args[1:] {
+		if err := push(args[0], item); err != nil {
+			c.dbError(err)
+			return
+		}
+	}
+	size, err := c.db.Qsize(args[0])
+	if err != nil {
+		c.dbError(err)
+		return
+	}
+	c.integer(size)
+}
+
+// lpop/rpop key [count]
+func respPop(c *respConn, cmd string, args [][]byte) {
+	pop := c.db.QpopFront
+	if cmd == "rpop" {
+		pop = c.db.QpopBack
+	}
+	if len(args) == 1 {
+		item, err := pop(args[0])
+		if err != nil {
+			c.dbError(err)
+			return
+		}
+		c.bulk(item)
+		return
+	}
+
+	count, ok := parseInt(args[1])
+	if !ok || count < 0 {
+		c.error("ERR value is out of range, must be positive")
+		return
+	}
+	var items [][]byte
+	for ; count > 0; count-- {
+		item, err := pop(args[0])
+		if err == emssdb.ErrNotFound {
+			break
+		} else if err != nil {
+			c.dbError(err)
+			return
+		}
+		items = append(items, item)
+	}
+	if len(items) == 0 {
+		if c.proto >= 3 {
+			c.null()
+		} else {
+			c.w.WriteString("*-1\r\n")
+		}
+		return
+	}
+	c.array(len(items))
+	for _, item := range items {
+		c.bulk(item)
+	}
+}
+
+func respLlen(c *respConn, cmd string, args [][]byte) {
+	size, err := c.db.Qsize(args[0])
+	if err != nil && err != emssdb.ErrNotFound {
+		c.dbError(err)
+		return
+	}
+	c.integer(size)
+}
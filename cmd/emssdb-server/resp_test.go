@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/neverlee/emssdb"
+)
+
+// openServerDB open a db in a temporary directory, closed at the end of the test
+func openServerDB(t *testing.T) (db *emssdb.DB) {
+	t.Helper()
+	db, err := emssdb.OpenDB(emssdb.Options{Path: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(db.Close)
+	return db
+}
+
+// listenLoopback listen on a free loopback port, closed at the end of the test
+func listenLoopback(t *testing.T) (ln net.Listener) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ln
+}
+
+type respClient struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// startRESP serve db by the redis protocol on loopback
+func startRESP(t *testing.T, db *emssdb.DB) (addr string) {
+	ln := listenLoopback(t)
+	server := NewRESPServer(db)
+	go server.Serve(ln)
+	t.Cleanup(func() {
+		ln.Close()
+		server.Close()
+	})
+	return ln.Addr().String()
+}
+
+func dialRESP(t *testing.T, addr string) (c *respClient) {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &respClient{t: t, conn: conn, r: bufio.NewReader(conn)}
+}
+
+// encode a request as an array of bulk strings
+func encodeRESP(args ...string) (ret string) {
+	ret = "*" + strconv.Itoa(len(args)) + "\r\n"
+	for _, arg := range args {
+		ret += "$" + strconv.Itoa(len(arg)) + "\r\n" + arg + "\r\n"
+	}
+	return ret
+}
+
+// do send a request and return its reply, see reply
+func (c *respClient) do(args ...string) (ret string) {
+	c.t.Helper()
+	if _, err := c.conn.Write([]byte(encodeRESP(args...))); err != nil {
+		c.t.Fatal(err)
+	}
+	return c.reply()
+}
+
+// reply read a reply: the status, error and integer lines as they are,
+// the bulk strings quoted, nil for the nulls, and the arrays and maps in brackets
+func (c *respClient) reply() (ret string) {
+	c.t.Helper()
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	switch line[0] {
+	case '$':
+		n, _ := strconv.Atoi(line[1:])
+		if n < 0 {
+			return "nil"
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, buf); err != nil {
+			c.t.Fatal(err)
+		}
+		return strconv.Quote(string(buf[:n]))
+	case '*', '%':
+		n, _ := strconv.Atoi(line[1:])
+		if line[0] == '%' {
+			n *= 2
+		}
+		items := make([]string, n)
+		for i := range items {
+			items[i] = c.reply()
+		}
+		return "[" + strings.Join(items, " ") + "]"
+	case '_':
+		return "nil"
+	}
+	return line
+}
+
+func TestRESPFraming(t *testing.T) {
+	c := dialRESP(t, startRESP(t, openServerDB(t)))
+	// a pipeline of an inline command and two arrays, with a binary value
+	value := "a\r\nb\x00c"
+	c.conn.Write([]byte("PING\r\n" + encodeRESP("SET", "k", value) + encodeRESP("GET", "k")))
+	for _, want := range []string{"+PONG", "+OK", strconv.Quote(value)} {
+		if got := c.reply(); got != want {
+			t.Fatalf("%s, want %s", got, want)
+		}
+	}
+	if got := c.do("HELLO", "3"); !strings.HasPrefix(got, "[") {
+		t.Fatal("hello", got)
+	}
+	if got := c.do("GET", "missing"); got != "nil" {
+		t.Fatal("resp3 null", got)
+	}
+	c.conn.Write([]byte("*1\r\n$x\r\n"))
+	if got := c.reply(); !strings.HasPrefix(got, "-ERR Protocol error") {
+		t.Fatal("bad packet", got)
+	}
+}
+
+func TestRESPCommands(t *testing.T) {
+	c := dialRESP(t, startRESP(t, openServerDB(t)))
+	for _, step := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"NOSUCH"}, "-ERR unknown command 'nosuch'"},
+		{[]string{"GET"}, "-ERR wrong number of arguments for 'get' command"},
+		{[]string{"GET", "k"}, "nil"},
+		{[]string{"SET", "k", "v", "EX", "100"}, "+OK"},
+		{[]string{"TTL", "k"}, ":100"},
+		{[]string{"SET", "k", "v2"}, "+OK"},
+		{[]string{"TTL", "k"}, ":-1"},
+		{[]string{"EXPIRE", "k", "100"}, ":1"},
+		{[]string{"EXISTS", "k", "none"}, ":1"},
+		{[]string{"PERSIST", "k"}, ":1"},
+		{[]string{"PERSIST", "k"}, ":0"},
+		{[]string{"GET", "k"}, `"v2"`},
+		{[]string{"EXPIRE", "none", "100"}, ":0"},
+		{[]string{"EXPIRE", "k", "-1"}, ":1"},
+		{[]string{"GET", "k"}, "nil"},
+		{[]string{"MSET", "a", "1", "b", "2"}, "+OK"},
+		{[]string{"DEL", "a", "b", "c"}, ":2"},
+		{[]string{"INCRBY", "n", "x"}, "-ERR value is not an integer or out of range"},
+		{[]string{"HSET", "h", "f1", "1", "f2", "2"}, ":2"},
+		{[]string{"HSET", "h", "f1", "3", "f3", "3"}, ":1"},
+		{[]string{"HDEL", "h", "f1", "none"}, ":1"},
+		{[]string{"HGETALL", "h"}, `["f2" "2" "f3" "3"]`},
+		{[]string{"ZADD", "z", "1", "a", "2.0", "b", "3", "c"}, ":3"},
+		{[]string{"ZADD", "z", "1.5", "a"}, "-ERR value is not an integer or out of range"},
+		{[]string{"ZADD", "z", "5", "a", "4", "d"}, ":1"},
+		{[]string{"ZREM", "z", "d", "none"}, ":1"},
+		{[]string{"ZRANGEBYSCORE", "z", "(2", "+inf", "WITHSCORES"}, `["c" "3" "a" "5"]`},
+		{[]string{"ZREVRANGEBYSCORE", "z", "3", "-inf", "LIMIT", "1", "1"}, `["b"]`},
+		{[]string{"RENAME", "none", "x"}, "-ERR no such key"},
+		{[]string{"SET", "x", "v"}, "+OK"},
+		{[]string{"RENAMENX", "h", "x"}, ":0"},
+		{[]string{"RENAME", "h", "x"}, "+OK"},
+		{[]string{"TYPE", "x"}, "+hash"},
+		{[]string{"RPUSH", "l", "a", "b"}, ":2"},
+		{[]string{"LPOP", "l"}, `"a"`},
+	} {
+		if got := c.do(step.args...); got != step.want {
+			t.Errorf("%q: %s, want %s", step.args, got, step.want)
+		}
+	}
+}
+
+// TestRESPConcurrentHset check that a new field is counted by one of the concurrent HSETs
+func TestRESPConcurrentHset(t *testing.T) {
+	addr := startRESP(t, openServerDB(t))
+	clients := make([]*respClient, 8)
+	for i := range clients {
+		clients[i] = dialRESP(t, addr)
+	}
+	for round := 0; round < 20; round++ {
+		field := fmt.Sprint("f", round)
+		var wg sync.WaitGroup
+		replies := make([]string, len(clients))
+		for i, c := range clients {
+			wg.Add(1)
+			go func(i int, c *respClient) {
+				defer wg.Done()
+				c.conn.Write([]byte(encodeRESP("HSET", "h", field, "v", "g"+field, "v")))
+				line, _ := c.r.ReadString('\n')
+				replies[i] = strings.TrimSpace(line)
+			}(i, c)
+		}
+		wg.Wait()
+		var news int
+		for _, reply := range replies {
+			n, _ := strconv.Atoi(strings.TrimPrefix(reply, ":"))
+			news += n
+		}
+		if news != 2 {
+			t.Fatalf("round %d: %d new fields counted, %q", round, news, replies)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"github.com/neverlee/emssdb"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	ssdbMaxBlock = 64 * 1024 * 1024
+)
+
+var (
+	errBadPacket = errors.New("bad packet")
+)
+
+// SSDBServer serve a emssdb by the ssdb text protocol
+type SSDBServer struct {
+	db    *emssdb.DB
+	mutex sync.Mutex
+	conns map[net.Conn]bool
+	wait  sync.WaitGroup
+}
+
+// NewSSDBServer return a ssdb protocol server of db
+func NewSSDBServer(db *emssdb.DB) *SSDBServer {
+	var s SSDBServer
+	s.db = db
+	s.conns = make(map[net.Conn]bool)
+	return &s
+}
+
+// Serve accept connections from ln until it is closed
+func (s *SSDBServer) Serve(ln net.Listener) (err error) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		s.mutex.Lock()
+		s.conns[conn] = true
+		s.mutex.Unlock()
+		s.wait.Add(1)
+		go s.handle(conn)
+	}
+}
+
+// Close close all client connections and wait for the handlers
+func (s *SSDBServer) Close() {
+	s.mutex.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mutex.Unlock()
+	s.wait.Wait()
+}
+
+func (s *SSDBServer) handle(conn net.Conn) {
+	defer func() {
+		s.mutex.Lock()
+		delete(s.conns, conn)
+		s.mutex.Unlock()
+		conn.Close()
+		s.wait.Done()
+	}()
+
+	reader := bufio.NewReader(conn)
+	writer := bufio.NewWriter(conn)
+	for {
+		req, err := readSSDBRequest(reader)
+		if err != nil {
+			if err != io.EOF {
+				writeSSDBResponse(writer, ssdbClientError(err.Error()))
+				writer.Flush()
+			}
+			return
+		}
+		if len(req) == 0 {
+			continue
+		}
+		writeSSDBResponse(writer, s.do(req))
+		if reader.Buffered() == 0 {
+			if err := writer.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (s *SSDBServer) do(req [][]byte) (resp [][]byte) {
+	cmd := strings.ToLower(string(req[0]))
+	handler, ok := ssdbCommands[cmd]
+	if !ok {
+		return ssdbClientError("Unknown Command: " + cmd)
+	}
+	return handler(s.db, cmd, req[1:])
+}
+
+// [len]\n[data]\n ... \n
+func readSSDBRequest(reader *bufio.Reader) (req [][]byte, err error) {
+	for {
+		line, err := reader.ReadSlice('\n')
+		if err != nil {
+			if err == io.EOF && len(line) > 0 {
+				err = errBadPacket
+			}
+			return nil, err
+		}
+		sline := strings.TrimRight(string(line), "\r\n")
+		if len(sline) == 0 {
+			return req, nil
+		}
+		size, err := strconv.Atoi(sline)
+		if err != nil || size < 0 || size > ssdbMaxBlock {
+			return nil, errBadPacket
+		}
+		block := make([]byte, size+1)
+		if _, err := io.ReadFull(reader, block); err != nil {
+			return nil, err
+		}
+		if block[size] == '\r' {
+			if b, err := reader.ReadByte(); err != nil || b != '\n' {
+				return nil, errBadPacket
+			}
+		} else if block[size] != '\n' {
+			return nil, errBadPacket
+		}
+		req = append(req, block[:size])
+	}
+}
+
+func writeSSDBResponse(writer *bufio.Writer, resp [][]byte) {
+	for _, block := range resp {
+		writer.WriteString(strconv.Itoa(len(block)))
+		writer.WriteByte('\n')
+		writer.Write(block)
+		writer.WriteByte('\n')
+	}
+	writer.WriteByte('\n')
+}
+
+func ssdbReply(status string, blocks ...[]byte) (resp [][]byte) {
+	resp = make([][]byte, 0, len(blocks)+1)
+	resp = append(resp, []byte(status))
+	return append(resp, blocks...)
+}
+
+func ssdbOk(blocks ...[]byte) (resp [][]byte) {
+	return ssdbReply("ok", blocks...)
+}
+
+func ssdbNotFound() (resp [][]byte) {
+	return ssdbReply("not_found")
+}
+
+func ssdbClientError(msg string) (resp [][]byte) {
+	return ssdbReply("client_error", []byte(msg))
+}
+
+func ssdbError(err error) (resp [][]byte) {
+	if err == emssdb.ErrNotFound {
+		return ssdbNotFound()
+	}
+	return ssdbReply("error", []byte(err.Error()))
+}
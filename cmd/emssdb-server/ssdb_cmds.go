@@ -0,0 +1,788 @@
+package main
+
+import (
+	"fmt"
+	"github.com/neverlee/emssdb"
+	"math"
+	"strconv"
+	"time"
+)
+
+type ssdbHandler func(db *emssdb.DB, cmd string, args [][]byte) (resp [][]byte)
+
+var ssdbCommands = map[string]ssdbHandler{
+	"ping":    ssdbPing,
+	"info":    ssdbInfo,
+	"compact": ssdbCompact,
+	// kv
+	"set":       ssdbSet,
+	"setx":      ssdbSetx,
+	"get":       ssdbGet,
+	"del":       ssdbDel,
+	"incr":      ssdbIncr,
+	"exists":    ssdbExists,
+	"ttl":       ssdbTTL,
+	"expire":    ssdbExpire,
+	"multi_set": ssdbMultiSet,
+	"multi_get": ssdbMultiGet,
+	"multi_del": ssdbMultiDel,
+	"scan":      ssdbScan,
+	"rscan":     ssdbScan,
+	"keys":      ssdbScan,
+	"rkeys":     ssdbScan,
+	// hash
+	"hset":    ssdbHset,
+	"hget":    ssdbHget,
+	"hdel":    ssdbHdel,
+	"hincr":   ssdbHincr,
+	"hexists": ssdbHexists,
+	"hsize":   ssdbHsize,
+	"hlist":   ssdbHlist,
+	"hscan":   ssdbHscan,
+	"hrscan":  ssdbHscan,
+	"hkeys":   ssdbHscan,
+	"hgetall": ssdbHgetall,
+	"hclear":  ssdbClear,
+	// zset
+	"zset":    ssdbZset,
+	"zget":    ssdbZget,
+	"zdel":    ssdbZdel,
+	"zincr":   ssdbZincr,
+	"zexists": ssdbZexists,
+	"zsize":   ssdbZsize,
+	"zlist":   ssdbZlist,
+	"zscan":   ssdbZscan,
+	"zrscan":  ssdbZscan,
+	"zkeys":   ssdbZscan,
+	"zclear":  ssdbClear,
+	// queue
+	"qpush_front": ssdbQpush,
+	"qpush_back":  ssdbQpush,
+	"qpush":       ssdbQpush,
+	"qpop_front":  ssdbQpop,
+	"qpop_back":   ssdbQpop,
+	"qpop":        ssdbQpop,
+	"qfront":      ssdbQfront,
+	"qback":       ssdbQback,
+	"qsize":       ssdbQsize,
+	"qlist":       ssdbQlist,
+	"qclear":      ssdbClear,
+}
+
+var (
+	replyOne  = []byte("1")
+	replyZero = []byte("0")
+)
+
+func wrongArgs() (resp [][]byte) {
+	return ssdbClientError("wrong number of arguments")
+}
+
+func itob(i int64) (ret []byte) {
+	return []byte(strconv.FormatInt(i, 10))
+}
+
+func btoi(b []byte) (i int64, err error) {
+	return strconv.ParseInt(string(b), 10, 64)
+}
+
+// after return the smallest key which is greater than key,
+// so ssdb's (start, end] ranges can be fed to the [start, end) scans
+func after(key []byte) (ret emssdb.Bytes) {
+	if len(key) == 0 {
+		return nil
+	}
+	ret = make(emssdb.Bytes, len(key)+1)
+	copy(ret, key)
+	return ret
+}
+
+func parseLimit(b []byte) (limit int64, ok bool) {
+	limit, err := btoi(b)
+	if err != nil || limit < 0 {
+		return 0, false
+	}
+	return limit, true
+}
+
+func ssdbPing(db *emssdb.DB, cmd string, args [][]byte) (resp [][]byte) {
+	return ssdbOk()
+}
+
+func ssdbInfo(db *emssdb.DB, cmd string, args [][]byte) (resp [][]byte) {
+	resp = ssdbOk([]byte("emssdb"))
+	for key, val := range db.Info() {
+		resp = append(resp, []byte(key), []byte(val))
+	}
+	kr := db.KeyRange()
+	for _, r := range []struct {
+		name   string
+		bounds emssdb.KeyBounds
+	}{{"kv", kr.KV}, {"hash", kr.Hash}, {"zset", kr.Zset}, {"list", kr.Queue}} {
+		resp = append(resp, []byte("key_range."+r.name),
+			[]byte(fmt.Sprintf("%q - %q", r.bounds.First, r.bounds.Last)))
+	}
+	return resp
+}
+
+func ssdbCompact(db *emssdb.DB, cmd string, args [][]byte) (resp [][]byte) {
+	if err := db.Compact(); err != nil {
+		return ssdbError(err)
+	}
+	return ssdbOk()
+}
+
+/***** KV *****/
+
+func ssdbSet(db *emssdb.DB, cmd string, args [][]byte) (resp [][]byte) {
+	if len(args) != 2 {
+		return wrongArgs()
+	}
+	err := db.Update(func(tx *emssdb.Tx) error {
+		if err := tx.Set(args[0], args[1]); err != nil {
+			return err
+		}
+		return tx.Edel(args[0])
+	})
+	if err != nil {
+		return ssdbError(err)
+	}
+	return ssdbOk(replyOne)
+}
+
+// setx store the key in the exkv space, the kv one is removed
+func ssdbSetx(db *emssdb.DB, cmd string, args [][]byte) (resp [][]byte) {
+	if len(args) != 3 {
+		return wrongArgs()
+	}
+	ttl, err := btoi(args[2])
+	if err != nil || ttl <= 0 {
+		return ssdbClientError("invalid ttl")
+	}
+	etime := uint64(time.Now().Unix() + ttl)
+	err = db.Update(func(tx *emssdb.Tx) error {
+		if err := tx.Eset(args[0], args[1], etime); err != nil {
+			return err
+		}
+		return tx.Del(args[0])
+	})
+	if err != nil {
+		return ssdbError(err)
+	}
+	return ssdbOk(replyOne)
+}
+
+// kvReader is the kv read side of a DB and of a Tx
+type kvReader interface {
+	Get(key emssdb.Bytes) (ret emssdb.Bytes, err error)
+	Eget(key emssdb.Bytes) (ret emssdb.Bytes, stamp uint64, err error)
+}
+
+// getAny look up the kv space first and then the unexpired exkv
+func getAny(db kvReader, key []byte) (val []byte, err error) {
+	val, err = db.Get(key)
+	if err != emssdb.ErrNotFound {
+		return val, err
+	}
+	val, etime, err := db.Eget(key)
+	if err != nil {
+		return nil, err
+	}
+	if etime == 0 || etime <= uint64(time.Now().Unix()) {
+		return nil, emssdb.ErrNotFound
+	}
+	return val, nil
+}
+
+func ssdbGet(db *emssdb.DB, cmd string, args [][]byte) (resp [][]byte) {
+	if len(args) != 1 {
+		return wrongArgs()
+	}
+	val, err := getAny(db, args[0])
+	if err != nil {
+		return ssdbError(err)
+	}
+	return ssdbOk(val)
+}
+
+func ssdbDel(db *emssdb.DB, cmd string, args [][]byte) (resp [][]byte) {
+	if len(args) != 1 {
+		return wrongArgs()
+	}
+	err := db.Update(func(tx *emssdb.Tx) error {
+		if err := tx.Del(args[0]); err != nil {
+			return err
+		}
+		return tx.Edel(args[0])
+	})
+	if err != nil {
+		return ssdbError(err)
+	}
+	return ssdbOk(replyOne)
+}
+
+func ssdbIncr(db *emssdb.DB, cmd string, args [][]byte) (resp [][]byte) {
+	if len(args) < 1 || len(args) > 2 {
+		return wrongArgs()
+	}
+	by := int64(1)
+	if len(args) == 2 {
+		var err error
+		if by, err = btoi(args[1]); err != nil {
+			return ssdbClientError("invalid incr value")
+		}
+	}
+	val, err := db.Incr(args[0], by)
+	if err != nil {
+		return ssdbError(err)
+	}
+	return ssdbOk(itob(val))
+}
+
+func ssdbExists(db *emssdb.DB, cmd string, args [][]byte) (resp [][]byte) {
+	if len(args) != 1 {
+		return wrongArgs()
+	}
+	if _, err := getAny(db, args[0]); err == emssdb.ErrNotFound {
+		return ssdbOk(replyZero)
+	} else if err != nil {
+		return ssdbError(err)
+	}
+	return ssdbOk(replyOne)
+}
+
+func ssdbTTL(db *emssdb.DB, cmd string, args [][]byte) (resp [][]byte) {
+	if len(args) != 1 {
+		return wrongArgs()
+	}
+	_, etime, err := db.Eget(args[0])
+	if err != nil {
+		return ssdbError(err)
+	}
+	now := uint64(time.Now().Unix())
+	if etime <= now {
+		return ssdbOk(itob(-1))
+	}
+	return ssdbOk(itob(int64(etime - now)))
+}
+
+func ssdbExpire(db *emssdb.DB, cmd string, args [][]byte) (resp [][]byte) {
+	if len(args) != 2 {
+		return wrongArgs()
+	}
+	ttl, err := btoi(args[1])
+	if err != nil || ttl <= 0 {
+		return ssdbClientError("invalid ttl")
+	}
+	etime := uint64(time.Now().Unix() + ttl)
+	err = db.Update(func(tx *emssdb.Tx) error {
+		val, err := getAny(tx, args[0])
+		if err != nil {
+			return err
+		}
+		if err = tx.Eset(args[0], val, etime); err != nil {
+			return err
+		}
+		return tx.Del(args[0])
+	})
+	if err == emssdb.ErrNotFound {
+		return ssdbOk(replyZero)
+	} else if err != nil {
+		return ssdbError(err)
+	}
+	return ssdbOk(replyOne)
+}
+
+func ssdbMultiSet(db *emssdb.DB, cmd string, args [][]byte) (resp [][]byte) {
+	if len(args) == 0 || len(args)%2 != 0 {
+		return wrongArgs()
+	}
+	var keys, vals []emssdb.Bytes
+	for i := 0; i < len(args); i += 2 {
+		keys = append(keys, args[i])
+		vals = append(vals, args[i+1])
+	}
+	if err := db.MultiSet(keys, vals); err != nil {
+		return ssdbError(err)
+	}
+	return ssdbOk(itob(int64(len(keys))))
+}
+
+func ssdbMultiGet(db *emssdb.DB, cmd string, args [][]byte) (resp [][]byte) {
+	if len(args) == 0 {
+		return wrongArgs()
+	}
+	resp = ssdbOk()
+	for _, key := range args {
+		if val, err := getAny(db, key); err == nil {
+			resp = append(resp, key, val)
+		}
+	}
+	return resp
+}
+
+func ssdbMultiDel(db *emssdb.DB, cmd string, args [][]byte) (resp [][]byte) {
+	if len(args) == 0 {
+		return wrongArgs()
+	}
+	var keys []emssdb.Bytes
+	for _, key := range args {
+		keys = append(keys, key)
+	}
+	if err := db.MultiDelete(keys); err != nil {
+		return ssdbError(err)
+	}
+	return ssdbOk(itob(int64(len(keys))))
+}
+
+// scan/keys start end limit: (start, end]
+// rscan/rkeys start end limit: [end, start)
+func ssdbScan(db *emssdb.DB, cmd string, args [][]byte) (resp [][]byte) {
+	if len(args) != 3 {
+		return wrongArgs()
+	}
+	limit, ok := parseLimit(args[2])
+	if !ok {
+		return ssdbClientError("invalid limit")
+	}
+	var kit *emssdb.KIterator
+	if cmd == "rscan" || cmd == "rkeys" {
+		kit = db.Rscan(args[1], args[0])
+	} else {
+		kit = db.Scan(after(args[0]), after(args[1]))
+	}
+	defer kit.Close()
+	withValue := cmd == "scan" || cmd == "rscan"
+	resp = ssdbOk()
+	for ; limit > 0 && kit.Next(); limit-- {
+		resp = append(resp, kit.Key())
+		if withValue {
+			resp = append(resp, kit.Value())
+		}
+	}
+	return resp
+}
+
+/***** HASH *****/
+
+func ssdbHset(db *emssdb.DB, cmd string, args [][]byte) (resp [][]byte) {
+	if len(args) != 3 {
+		return wrongArgs()
+	}
+	if err := db.Hset(args[0], args[1], args[2]); err != nil {
+		return ssdbError(err)
+	}
+	return ssdbOk(replyOne)
+}
+
+func ssdbHget(db *emssdb.DB, cmd string, args [][]byte) (resp [][]byte) {
+	if len(args) != 2 {
+		return wrongArgs()
+	}
+	val, err := db.Hget(args[0], args[1])
+	if err != nil {
+		return ssdbError(err)
+	}
+	return ssdbOk(val)
+}
+
+// hdel name key: 1 if the field was deleted, 0 if it did not exist
+func ssdbHdel(db *emssdb.DB, cmd string, args [][]byte) (resp [][]byte) {
+	if len(args) != 2 {
+		return wrongArgs()
+	}
+	err := db.Update(func(tx *emssdb.Tx) error {
+		if _, err := tx.Hget(args[0], args[1]); err != nil {
+			return err
+		}
+		return tx.Hdel(args[0], args[1])
+	})
+	if err == emssdb.ErrNotFound {
+		return ssdbOk(replyZero)
+	} else if err != nil {
+		return ssdbError(err)
+	}
+	return ssdbOk(replyOne)
+}
+
+func ssdbHincr(db *emssdb.DB, cmd string, args [][]byte) (resp [][]byte) {
+	if len(args) < 2 || len(args) > 3 {
+		return wrongArgs()
+	}
+	by := int64(1)
+	if len(args) == 3 {
+		var err error
+		if by, err = btoi(args[2]); err != nil {
+			return ssdbClientError("invalid incr value")
+		}
+	}
+	val, err := db.Hincr(args[0], args[1], by)
+	if err != nil {
+		return ssdbError(err)
+	}
+	return ssdbOk(itob(val))
+}
+
+func ssdbHexists(db *emssdb.DB, cmd string, args [][]byte) (resp [][]byte) {
+	if len(args) != 2 {
+		return wrongArgs()
+	}
+	if _, err := db.Hget(args[0], args[1]); err == emssdb.ErrNotFound {
+		return ssdbOk(replyZero)
+	} else if err != nil {
+		return ssdbError(err)
+	}
+	return ssdbOk(replyOne)
+}
+
+func ssdbHsize(db *emssdb.DB, cmd string, args [][]byte) (resp [][]byte) {
+	if len(args) != 1 {
+		return wrongArgs()
+	}
+	size, err := db.Hsize(args[0])
+	if err != nil && err != emssdb.ErrNotFound {
+		return ssdbError(err)
+	}
+	return ssdbOk(itob(size))
+}
+
+// hclear/zclear/qclear name: the number of the items removed
+func ssdbClear(db *emssdb.DB, cmd string, args [][]byte) (resp [][]byte) {
+	if len(args) != 1 {
+		return wrongArgs()
+	}
+	clear := map[string]func(name emssdb.Bytes) (int64, error){
+		"hclear": db.Hclear,
+		"zclear": db.Zclear,
+		"qclear": db.Qclear,
+	}[cmd]
+	n, err := clear(args[0])
+	if err != nil {
+		return ssdbError(err)
+	}
+	return ssdbOk(itob(n))
+}
+
+// hlist/zlist/qlist name_start name_end limit: (name_start, name_end]
+func listReply(list []emssdb.Bytes, args [][]byte) (resp [][]byte) {
+	limit, ok := parseLimit(args[2])
+	if !ok {
+		return ssdbClientError("invalid limit")
+	}
+	resp = ssdbOk()
+	for _, name := range list {
+		if int64(len(resp)) > limit {
+			break
+		}
+		resp = append(resp, name)
+	}
+	return resp
+}
+
+func ssdbHlist(db *emssdb.DB, cmd string, args [][]byte) (resp [][]byte) {
+	if len(args) != 3 {
+		return wrongArgs()
+	}
+	return listReply(db.Hlist(after(args[0]), after(args[1])), args)
+}
+
+// hscan/hkeys name key_start key_end limit: (key_start, key_end]
+// hrscan name key_start key_end limit: [key_end, key_start)
+func ssdbHscan(db *emssdb.DB, cmd string, args [][]byte) (resp [][]byte) {
+	if len(args) != 4 {
+		return wrongArgs()
+	}
+	limit, ok := parseLimit(args[3])
+	if !ok {
+		return ssdbClientError("invalid limit")
+	}
+	var hit *emssdb.HIterator
+	if cmd == "hrscan" {
+		hit = db.Hrscan(args[0], args[2], args[1])
+	} else {
+		hit = db.Hscan(args[0], after(args[1]), after(args[2]))
+	}
+	defer hit.Close()
+	resp = ssdbOk()
+	for ; limit > 0 && hit.Next(); limit-- {
+		resp = append(resp, hit.Key())
+		if cmd != "hkeys" {
+			resp = append(resp, hit.Value())
+		}
+	}
+	return resp
+}
+
+func ssdbHgetall(db *emssdb.DB, cmd string, args [][]byte) (resp [][]byte) {
+	if len(args) != 1 {
+		return wrongArgs()
+	}
+	hit := db.Hscan(args[0], nil, nil)
+	defer hit.Close()
+	resp = ssdbOk()
+	for hit.Next() {
+		resp = append(resp, hit.Key(), hit.Value())
+	}
+	return resp
+}
+
+/***** ZSET *****/
+
+func ssdbZset(db *emssdb.DB, cmd string, args [][]byte) (resp [][]byte) {
+	if len(args) != 3 {
+		return wrongArgs()
+	}
+	score, err := btoi(args[2])
+	if err != nil {
+		return ssdbClientError("invalid score")
+	}
+	if err := db.Zset(args[0], args[1], score); err != nil {
+		return ssdbError(err)
+	}
+	return ssdbOk(replyOne)
+}
+
+func ssdbZget(db *emssdb.DB, cmd string, args [][]byte) (resp [][]byte) {
+	if len(args) != 2 {
+		return wrongArgs()
+	}
+	score, err := db.Zget(args[0], args[1])
+	if err != nil {
+		return ssdbError(err)
+	}
+	return ssdbOk(itob(score))
+}
+
+// zdel name key: 1 if the member was deleted, 0 if it did not exist
+func ssdbZdel(db *emssdb.DB, cmd string, args [][]byte) (resp [][]byte) {
+	if len(args) != 2 {
+		return wrongArgs()
+	}
+	err := db.Update(func(tx *emssdb.Tx) error {
+		if _, err := tx.Zget(args[0], args[1]); err != nil {
+			return err
+		}
+		return tx.Zdel(args[0], args[1])
+	})
+	if err == emssdb.ErrNotFound {
+		return ssdbOk(replyZero)
+	} else if err != nil {
+		return ssdbError(err)
+	}
+	return ssdbOk(replyOne)
+}
+
+func ssdbZincr(db *emssdb.DB, cmd string, args [][]byte) (resp [][]byte) {
+	if len(args) < 2 || len(args) > 3 {
+		return wrongArgs()
+	}
+	by := int64(1)
+	if len(args) == 3 {
+		var err error
+		if by, err = btoi(args[2]); err != nil {
+			return ssdbClientError("invalid incr value")
+		}
+	}
+	score, err := db.Zincr(args[0], args[1], by)
+	if err != nil {
+		return ssdbError(err)
+	}
+	return ssdbOk(itob(score))
+}
+
+func ssdbZexists(db *emssdb.DB, cmd string, args [][]byte) (resp [][]byte) {
+	if len(args) != 2 {
+		return wrongArgs()
+	}
+	if _, err := db.Zget(args[0], args[1]); err == emssdb.ErrNotFound {
+		return ssdbOk(replyZero)
+	} else if err != nil {
+		return ssdbError(err)
+	}
+	return ssdbOk(replyOne)
+}
+
+func ssdbZsize(db *emssdb.DB, cmd string, args [][]byte) (resp [][]byte) {
+	if len(args) != 1 {
+		return wrongArgs()
+	}
+	size, err := db.Zsize(args[0])
+	if err != nil && err != emssdb.ErrNotFound {
+		return ssdbError(err)
+	}
+	return ssdbOk(itob(size))
+}
+
+func ssdbZlist(db *emssdb.DB, cmd string, args [][]byte) (resp [][]byte) {
+	if len(args) != 3 {
+		return wrongArgs()
+	}
+	return listReply(db.Zlist(after(args[0]), after(args[1])), args)
+}
+
+func parseScore(b []byte, empty int64) (score int64, ok bool) {
+	if len(b) == 0 {
+		return empty, true
+	}
+	score, err := btoi(b)
+	return score, err == nil
+}
+
+// zscan/zkeys name key_start score_start score_end limit: score in
+// [score_start, score_end] and after (key_start, score_start).
+// zrscan is the same but score_start is the upper bound
+func ssdbZscan(db *emssdb.DB, cmd string, args [][]byte) (resp [][]byte) {
+	if len(args) != 5 {
+		return wrongArgs()
+	}
+	limit, ok := parseLimit(args[4])
+	if !ok {
+		return ssdbClientError("invalid limit")
+	}
+	reverse := cmd == "zrscan"
+	low, high := int64(math.MinInt64), int64(math.MaxInt64)
+	if reverse {
+		low, high = high, low
+	}
+	start, ok1 := parseScore(args[2], low)
+	end, ok2 := parseScore(args[3], high)
+	if !ok1 || !ok2 {
+		return ssdbClientError("invalid score")
+	}
+
+	// the scans exclude the upper score, widen it by one
+	first := start
+	var zit *emssdb.ZIterator
+	if reverse {
+		if start < math.MaxInt64 {
+			start++
+		}
+		zit = db.Zrscan(args[0], end, start)
+	} else {
+		if end < math.MaxInt64 {
+			end++
+		}
+		zit = db.Zscan(args[0], start, end)
+	}
+	defer zit.Close()
+
+	keyStart := string(args[1])
+	resp = ssdbOk()
+	for limit > 0 && zit.Next() {
+		if len(keyStart) > 0 && zit.Score() == first {
+			if (!reverse && string(zit.Key()) <= keyStart) ||
+				(reverse && string(zit.Key()) >= keyStart) {
+				continue
+			}
+		}
+		resp = append(resp, zit.Key())
+		if cmd != "zkeys" {
+			resp = append(resp, itob(zit.Score()))
+		}
+		limit--
+	}
+	return resp
+}
+
+/***** QUEUE *****/
+
+func ssdbQpush(db *emssdb.DB, cmd string, args [][]byte) (resp [][]byte) {
+	if len(args) < 2 {
+		return wrongArgs()
+	}
+	var size int64
+	err := db.Update(func(tx *emssdb.Tx) (err error) {
+		push := tx.QpushBack
+		if cmd == "qpush_front" {
+			push = tx.QpushFront
+		}
+		for _, item := range args[1:] {
+			if err = push(args[0], item); err != nil {
+				return err
+			}
+		}
+		size, err = tx.Qsize(args[0])
+		return err
+	})
+	if err != nil {
+		return ssdbError(err)
+	}
+	return ssdbOk(itob(size))
+}
+
+func ssdbQpop(db *emssdb.DB, cmd string, args [][]byte) (resp [][]byte) {
+	if len(args) < 1 || len(args) > 2 {
+		return wrongArgs()
+	}
+	size := int64(1)
+	if len(args) == 2 {
+		var ok bool
+		if size, ok = parseLimit(args[1]); !ok {
+			return ssdbClientError("invalid size")
+		}
+	}
+	err := db.Update(func(tx *emssdb.Tx) error {
+		pop := tx.QpopFront
+		if cmd == "qpop_back" {
+			pop = tx.QpopBack
+		}
+		resp = ssdbOk()
+		for ; size > 0; size-- {
+			item, err := pop(args[0])
+			if err == emssdb.ErrNotFound {
+				break
+			} else if err != nil {
+				return err
+			}
+			resp = append(resp, item)
+		}
+		return nil
+	})
+	if err != nil {
+		return ssdbError(err)
+	}
+	if len(resp) == 1 {
+		return ssdbNotFound()
+	}
+	return resp
+}
+
+func ssdbQfront(db *emssdb.DB, cmd string, args [][]byte) (resp [][]byte) {
+	if len(args) != 1 {
+		return wrongArgs()
+	}
+	item, err := db.Qfront(args[0])
+	if err != nil {
+		return ssdbError(err)
+	}
+	return ssdbOk(item)
+}
+
+func ssdbQback(db *emssdb.DB, cmd string, args [][]byte) (resp [][]byte) {
+	if len(args) != 1 {
+		return wrongArgs()
+	}
+	item, err := db.Qback(args[0])
+	if err != nil {
+		return ssdbError(err)
+	}
+	return ssdbOk(item)
+}
+
+func ssdbQsize(db *emssdb.DB, cmd string, args [][]byte) (resp [][]byte) {
+	if len(args) != 1 {
+		return wrongArgs()
+	}
+	size, err := db.Qsize(args[0])
+	if err != nil && err != emssdb.ErrNotFound {
+		return ssdbError(err)
+	}
+	return ssdbOk(itob(size))
+}
+
+func ssdbQlist(db *emssdb.DB, cmd string, args [][]byte) (resp [][]byte) {
+	if len(args) != 3 {
+		return wrongArgs()
+	}
+	return listReply(db.Qlist(after(args[0]), after(args[1])), args)
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/neverlee/emssdb"
+)
+
+type ssdbClient struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// startSSDB serve db by the ssdb protocol on loopback
+func startSSDB(t *testing.T, db *emssdb.DB) (addr string) {
+	ln := listenLoopback(t)
+	server := NewSSDBServer(db)
+	go server.Serve(ln)
+	t.Cleanup(func() {
+		ln.Close()
+		server.Close()
+	})
+	return ln.Addr().String()
+}
+
+func dialSSDB(t *testing.T, addr string) (c *ssdbClient) {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &ssdbClient{t: t, conn: conn, r: bufio.NewReader(conn)}
+}
+
+// encodeSSDB encode a request as the blocks [len]\n[data]\n ended by an empty line
+func encodeSSDB(args ...string) (ret string) {
+	for _, arg := range args {
+		ret += strconv.Itoa(len(arg)) + "\n" + arg + "\n"
+	}
+	return ret + "\n"
+}
+
+// do send a request and return the blocks of its response joined by spaces
+func (c *ssdbClient) do(args ...string) (ret string) {
+	c.t.Helper()
+	if _, err := c.conn.Write([]byte(encodeSSDB(args...))); err != nil {
+		c.t.Fatal(err)
+	}
+	return c.reply()
+}
+
+func (c *ssdbClient) reply() (ret string) {
+	c.t.Helper()
+	var blocks []string
+	for {
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			c.t.Fatal(err)
+		}
+		if line == "\n" {
+			return strings.Join(blocks, " ")
+		}
+		size, err := strconv.Atoi(strings.TrimSuffix(line, "\n"))
+		if err != nil {
+			c.t.Fatal("bad block size", line)
+		}
+		block := make([]byte, size+1)
+		if _, err := io.ReadFull(c.r, block); err != nil {
+			c.t.Fatal(err)
+		}
+		blocks = append(blocks, string(block[:size]))
+	}
+}
+
+func TestSSDBFraming(t *testing.T) {
+	c := dialSSDB(t, startSSDB(t, openServerDB(t)))
+	// a pipeline, with a binary value and the \r\n line ends
+	value := "a\n\r\nb\x00"
+	c.conn.Write([]byte(encodeSSDB("set", "k", value) + "3\r\nget\r\n1\r\nk\r\n\r\n" + encodeSSDB("ping")))
+	for _, want := range []string{"ok 1", "ok " + value, "ok"} {
+		if got := c.reply(); got != want {
+			t.Fatalf("%q, want %q", got, want)
+		}
+	}
+	c.conn.Write([]byte("x\n"))
+	if got := c.reply(); got != "client_error bad packet" {
+		t.Fatal("bad packet", got)
+	}
+}
+
+func TestSSDBCommands(t *testing.T) {
+	c := dialSSDB(t, startSSDB(t, openServerDB(t)))
+	for _, step := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"nosuch"}, "client_error Unknown Command: nosuch"},
+		{[]string{"get"}, "client_error wrong number of arguments"},
+		{[]string{"get", "k"}, "not_found"},
+		{[]string{"setx", "k", "v", "x"}, "client_error invalid ttl"},
+		{[]string{"set", "", "v"}, "error ssdb: empty key"},
+		{[]string{"setx", "k", "v", "100"}, "ok 1"},
+		{[]string{"ttl", "k"}, "ok 100"},
+		{[]string{"set", "k", "v2"}, "ok 1"},
+		{[]string{"ttl", "k"}, "ok -1"},
+		{[]string{"expire", "k", "50"}, "ok 1"},
+		{[]string{"get", "k"}, "ok v2"},
+		{[]string{"expire", "none", "50"}, "ok 0"},
+		{[]string{"del", "k"}, "ok 1"},
+		{[]string{"exists", "k"}, "ok 0"},
+		{[]string{"multi_set", "a", "1", "b", "2", "c", "3", "d", "4"}, "ok 4"},
+		// (start, end]
+		{[]string{"scan", "a", "c", "10"}, "ok b 2 c 3"},
+		{[]string{"keys", "", "b", "10"}, "ok a b"},
+		// [end, start)
+		{[]string{"rscan", "d", "b", "10"}, "ok c 3 b 2"},
+		{[]string{"rkeys", "", "", "2"}, "ok d c"},
+		{[]string{"hset", "h", "f1", "1"}, "ok 1"},
+		{[]string{"hset", "h", "f2", "2"}, "ok 1"},
+		{[]string{"hset", "h", "f3", "3"}, "ok 1"},
+		{[]string{"hscan", "h", "f1", "", "10"}, "ok f2 2 f3 3"},
+		{[]string{"hrscan", "h", "f3", "f1", "10"}, "ok f2 2 f1 1"},
+		{[]string{"hdel", "h", "f1"}, "ok 1"},
+		{[]string{"hdel", "h", "f1"}, "ok 0"},
+		{[]string{"hsize", "h"}, "ok 2"},
+		{[]string{"hget", "h", "f1"}, "not_found"},
+		{[]string{"zset", "z", "a", "1"}, "ok 1"},
+		{[]string{"zset", "z", "b", "1"}, "ok 1"},
+		{[]string{"zset", "z", "c", "2"}, "ok 1"},
+		{[]string{"zset", "z", "d", "3"}, "ok 1"},
+		{[]string{"zset", "z", "e", "x"}, "client_error invalid score"},
+		// the score range is closed, the members of score_start after key_start
+		{[]string{"zscan", "z", "", "1", "2", "10"}, "ok a 1 b 1 c 2"},
+		{[]string{"zscan", "z", "a", "1", "", "10"}, "ok b 1 c 2 d 3"},
+		{[]string{"zkeys", "z", "", "", "", "2"}, "ok a b"},
+		{[]string{"zrscan", "z", "", "2", "1", "10"}, "ok c 2 b 1 a 1"},
+		{[]string{"zrscan", "z", "b", "1", "", "10"}, "ok a 1"},
+		{[]string{"zdel", "z", "a"}, "ok 1"},
+		{[]string{"zdel", "z", "a"}, "ok 0"},
+		{[]string{"zsize", "z"}, "ok 3"},
+		{[]string{"qpush_back", "q", "a", "b", "c"}, "ok 3"},
+		{[]string{"qpush_front", "q", "z"}, "ok 4"},
+		{[]string{"qpop_front", "q", "2"}, "ok z a"},
+		{[]string{"qpop_back", "q"}, "ok c"},
+		{[]string{"qpop", "none"}, "not_found"},
+		{[]string{"qclear", "q"}, "ok 1"},
+	} {
+		if got := c.do(step.args...); got != step.want {
+			t.Errorf("%q: %q, want %q", step.args, got, step.want)
+		}
+	}
+}
+
+// TestSSDBConcurrentHdel check that one of the concurrent hdels of a field deletes it
+func TestSSDBConcurrentHdel(t *testing.T) {
+	db := openServerDB(t)
+	addr := startSSDB(t, db)
+	clients := make([]*ssdbClient, 8)
+	for i := range clients {
+		clients[i] = dialSSDB(t, addr)
+	}
+	for round := 0; round < 20; round++ {
+		field := fmt.Sprint("f", round)
+		db.Hset(emssdb.Bytes("h"), emssdb.Bytes(field), emssdb.Bytes("v"))
+		var wg sync.WaitGroup
+		replies := make([]string, len(clients))
+		for i, c := range clients {
+			wg.Add(1)
+			go func(i int, c *ssdbClient) {
+				defer wg.Done()
+				c.conn.Write([]byte(encodeSSDB("hdel", "h", field)))
+				var blocks []string
+				for {
+					line, err := c.r.ReadString('\n')
+					if err != nil || line == "\n" {
+						break
+					}
+					block, _ := c.r.ReadString('\n')
+					blocks = append(blocks, strings.TrimSuffix(block, "\n"))
+				}
+				replies[i] = strings.Join(blocks, " ")
+			}(i, c)
+		}
+		wg.Wait()
+		deleted := 0
+		for _, reply := range replies {
+			if reply == "ok 1" {
+				deleted++
+			}
+		}
+		if deleted != 1 {
+			t.Fatalf("round %d: %d hdels deleted the field, %q", round, deleted, replies)
+		}
+	}
+}
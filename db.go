@@ -1,10 +1,12 @@
 package emssdb
 
 import (
+	"fmt"
 	"github.com/syndtr/goleveldb/leveldb"
 	"github.com/syndtr/goleveldb/leveldb/filter"
 	"github.com/syndtr/goleveldb/leveldb/opt"
 	"github.com/syndtr/goleveldb/leveldb/util"
+	"os"
 	//"runtime"
 	"sync"
 	"time"
@@ -12,68 +14,143 @@ import (
 
 // DB emssdb DB struct
 type DB struct {
+	view
 	db          *leveldb.DB
 	options     opt.Options
 	writer      *Writer
 	expireDelay time.Duration
-	end         bool
+	readOnly    bool
+	counters    *opCounters
+	epochs      *epochs
+	quit        chan struct{}
 	waitgroup   sync.WaitGroup
+	closeOnce   sync.Once
+	copyDir     string // the migrated copy of a read only db of an older format version
+
+	root      *DB    // the DB of a namespace, nil for a DB
+	namespace string // the name of the namespace
+	prefix    Bytes  // the key prefix of the namespace
 }
 
 // OpenDB open a emssdb by options
 func OpenDB(options Options) (that *DB, err error) {
 	mainDBPath := options.Path
-	cacheSize := options.CacheSize
-	writeBufferSize := 4
-	blockSize := 4
-	compression := options.Compression
 	if options.ExpireDelay <= time.Second {
 		options.ExpireDelay = time.Second
 	}
-
-	if cacheSize <= 0 {
-		cacheSize = 8
+	if options.SyncInterval <= 0 {
+		options.SyncInterval = time.Second
+	}
+	if options.MaxKeyLen <= 0 {
+		options.MaxKeyLen = 4096
 	}
 
 	//log::path,cacheSize,blockSize,write_buffer,compression
 
 	var d DB
-	d.options.ErrorIfMissing = false
-	d.options.Filter = filter.NewBloomFilter(10)
-	//d.Options.BlockCacher = leveldb::NewLRUCache(cacheSize * 1048576)
-	d.options.BlockCacheCapacity = cacheSize * 1024 * 1024
-	d.options.BlockSize = blockSize * 1024
-	d.options.WriteBuffer = writeBufferSize * 1024 * 1024
+	d.options = leveldbOptions(options)
 	d.expireDelay = options.ExpireDelay
-	if compression {
-		d.options.Compression = opt.SnappyCompression
-	} else {
-		d.options.Compression = opt.NoCompression
-	}
+	d.readOnly = options.ReadOnly
+	d.maxKeyLen = options.MaxKeyLen
+	d.quit = make(chan struct{})
+	d.counters = &opCounters{}
 
-	if tdb, err := leveldb.OpenFile(mainDBPath, &d.options); err == nil {
+	if tdb, copyDir, err := openFormat(mainDBPath, &d.options, options.MigrateProgress); err == nil {
 		//runtime.SetFinalizer(d,
 		//	func(d *DB) {
 		//		d.db.Close()
 		//	})
 		d.db = tdb
+		d.copyDir = copyDir
+		if d.epochs, err = openEpochs(tdb); err != nil {
+			d.closeLeveldb()
+			return nil, err
+		}
+		d.r = &countReader{tdb, d.counters}
+		if options.Metrics {
+			d.metrics = newMetrics()
+		}
 		d.writer = NewWriter(d.db)
-		go d.expireDaemon()
+		d.writer.Sync = options.Sync
+		d.writer.SyncInterval = options.SyncInterval
+		d.writer.GroupCommit = options.GroupCommit
+		if !d.readOnly {
+			// the daemon deletes the expired keys, a read only db keeps them
+			d.waitgroup.Add(1)
+			go d.expireDaemon()
+		}
+		if !d.readOnly && options.Sync == SyncByInterval {
+			d.waitgroup.Add(1)
+			go d.syncDaemon()
+		}
 		return &d, nil
 	} else {
 		return nil, err
 	}
 }
 
-// Close close emssdb
+// leveldbOptions the leveldb options of the emssdb options
+func leveldbOptions(options Options) (o opt.Options) {
+	cacheSize := options.CacheSize
+	writeBufferSize := options.WriteBufferSize
+	blockSize := options.BlockSize
+	bloomBits := options.BloomBits
+	if cacheSize <= 0 {
+		cacheSize = 8
+	}
+	if writeBufferSize <= 0 {
+		writeBufferSize = 4
+	}
+	if blockSize <= 0 {
+		blockSize = 4
+	}
+	if bloomBits == 0 {
+		bloomBits = 10
+	}
+
+	o.ErrorIfMissing = false
+	o.ErrorIfExist = options.ErrorIfExist
+	o.ReadOnly = options.ReadOnly
+	if bloomBits > 0 {
+		o.Filter = filter.NewBloomFilter(bloomBits)
+	}
+	//d.Options.BlockCacher = leveldb::NewLRUCache(cacheSize * 1048576)
+	o.BlockCacheCapacity = cacheSize * 1024 * 1024
+	o.BlockSize = blockSize * 1024
+	o.WriteBuffer = writeBufferSize * 1024 * 1024
+	o.OpenFilesCacheCapacity = options.OpenFilesCache
+	o.CompactionTableSize = options.CompactionTableSize * 1024 * 1024
+	if options.Compression {
+		o.Compression = opt.SnappyCompression
+	} else {
+		o.Compression = opt.NoCompression
+	}
+	return o
+}
+
+// Close close emssdb, the handle of a namespace is closed with its DB.
+// Closing it again does nothing.
 func (d *DB) Close() {
-	d.end = true
-	d.waitgroup.Wait()
+	if d.root != nil {
+		return
+	}
+	d.closeOnce.Do(func() {
+		close(d.quit)
+		d.waitgroup.Wait()
+		d.closeLeveldb()
+	})
+}
+
+// closeLeveldb close the leveldb, and remove its migrated copy
+func (d *DB) closeLeveldb() {
 	d.db.Close()
+	if d.copyDir != "" {
+		os.RemoveAll(d.copyDir)
+	}
 }
 
 // return (start, end], not include start
-func (d *DB) Iterator(start Bytes, end Bytes) (ret *Iterator) {
+func (v *view) Iterator(start Bytes, end Bytes) (ret *Iterator) {
 	if len(start) == 0 {
 		start = nil
 	}
@@ -82,11 +159,11 @@ func (d *DB) Iterator(start Bytes, end Bytes) (ret *Iterator) {
 	}
 	var iopt opt.ReadOptions
 	iopt.DontFillCache = true
-	it := d.db.NewIterator(&util.Range{Start: start, Limit: end}, &iopt)
+	it := v.r.NewIterator(&util.Range{Start: start, Limit: end}, &iopt)
 	return NewIterator(it, FORWARD)
 }
 
-func (d *DB) RevIterator(start Bytes, end Bytes) (ret *Iterator) {
+func (v *view) RevIterator(start Bytes, end Bytes) (ret *Iterator) {
 	if len(start) == 0 {
 		start = nil
 	}
@@ -95,7 +172,7 @@ func (d *DB) RevIterator(start Bytes, end Bytes) (ret *Iterator) {
 	}
 	var iopt opt.ReadOptions
 	iopt.DontFillCache = true
-	it := d.db.NewIterator(&util.Range{Start: start, Limit: end}, &iopt)
+	it := v.r.NewIterator(&util.Range{Start: start, Limit: end}, &iopt)
 	it.Last()
 	it.Next()
 	return NewIterator(it, BACKWARD)
@@ -108,16 +185,19 @@ func (d *DB) Info() (ret map[string]string) {
 	//     about the internal operation of the DB.
 	//  "leveldb.sstables" - returns a multi-line string that describes all
 	//     of the sstables that make up the db contents.
+	if d.root != nil {
+		return d.namespaceInfo()
+	}
 	var keys []string
 	info := make(map[string]string)
 
-	//for i := 0; i < 7; i++ {
-	//	s := fmt.Sprintf("leveldb.num-files-at-level%d", i)
-	//	keys = append(keys, s)
-	//}
+	for i := 0; i < 7; i++ {
+		s := fmt.Sprintf("leveldb.num-files-at-level%d", i)
+		keys = append(keys, s)
+	}
 
 	keys = append(keys, "leveldb.stats")
-	//keys = append(keys, "leveldb.sstables")
+	keys = append(keys, "leveldb.sstables")
 
 	for _, key := range keys {
 		if val, err := d.db.GetProperty(key); err == nil {
@@ -129,109 +209,135 @@ func (d *DB) Info() (ret map[string]string) {
 }
 
 func (d *DB) Compact() (err error) {
-	return d.db.CompactRange(util.Range{})
-}
-
-func (d *DB) KeyRange(keys []string) {
-	//	int ret = 0;
-	//	std::string kstart, kend;
-	//	std::string hstart, hend;
-	//	std::string zstart, zend;
-	//
-	//	Iterator *it;
-	//
-	//	it = d->iterator(encode_kv_key(""), "", 1);
-	//	if(it->next()){
-	//		Bytes ks = it->key();
-	//		if(ks.data()[0] == DataType::KV){
-	//			std::string n;
-	//			if(decode_kv_key(ks, &n) == -1){
-	//				ret = -1;
-	//			}else{
-	//				kstart = n;
-	//			}
-	//		}
-	//	}
-	//	delete it;
-	//
-	//	it = d->rev_iterator(encode_kv_key("\xff"), "", 1);
-	//	if(it->next()){
-	//		Bytes ks = it->key();
-	//		if(ks.data()[0] == DataType::KV){
-	//			std::string n;
-	//			if(decode_kv_key(ks, &n) == -1){
-	//				ret = -1;
-	//			}else{
-	//				kend = n;
-	//			}
-	//		}
-	//	}
-	//	delete it;
-	//
-	//	it = d->iterator(encode_hsize_key(""), "", 1);
-	//	if(it->next()){
-	//		Bytes ks = it->key();
-	//		if(ks.data()[0] == DataType::HSIZE){
-	//			std::string n;
-	//			if(decode_hsize_key(ks, &n) == -1){
-	//				ret = -1;
-	//			}else{
-	//				hstart = n;
-	//			}
-	//		}
-	//	}
-	//	delete it;
-	//
-	//	it = d->rev_iterator(encode_hsize_key("\xff"), "", 1);
-	//	if(it->next()){
-	//		Bytes ks = it->key();
-	//		if(ks.data()[0] == DataType::HSIZE){
-	//			std::string n;
-	//			if(decode_hsize_key(ks, &n) == -1){
-	//				ret = -1;
-	//			}else{
-	//				hend = n;
-	//			}
-	//		}
-	//	}
-	//	delete it;
-	//
-	//	it = d->iterator(encode_zsize_key(""), "", 1);
-	//	if(it->next()){
-	//		Bytes ks = it->key();
-	//		if(ks.data()[0] == DataType::ZSIZE){
-	//			std::string n;
-	//			if(decode_hsize_key(ks, &n) == -1){
-	//				ret = -1;
-	//			}else{
-	//				zstart = n;
-	//			}
-	//		}
-	//	}
-	//	delete it;
-	//
-	//	it = d->rev_iterator(encode_zsize_key("\xff"), "", 1);
-	//	if(it->next()){
-	//		Bytes ks = it->key();
-	//		if(ks.data()[0] == DataType::ZSIZE){
-	//			std::string n;
-	//			if(decode_hsize_key(ks, &n) == -1){
-	//				ret = -1;
-	//			}else{
-	//				zend = n;
-	//			}
-	//		}
-	//	}
-	//	delete it;
-	//
-	//	keys->push_back(kstart);
-	//	keys->push_back(kend);
-	//	keys->push_back(hstart);
-	//	keys->push_back(hend);
-	//	keys->push_back(zstart);
-	//	keys->push_back(zend);
-	//
-	//	return ret;
+	defer d.metrics.observe("compact", time.Now(), &err)
+	return d.compactRanges(util.Range{})
+}
+
+// CompactKV compact the kv keys in [start, end), an empty end for all the keys after start
+func (d *DB) CompactKV(start, end Bytes) (err error) {
+	defer d.metrics.observe("compactkv", time.Now(), &err)
+	return d.compactRanges(kvRange(start, end))
+}
+
+// CompactHash compact the items and the size of the hash name
+func (d *DB) CompactHash(name Bytes) (err error) {
+	defer d.metrics.observe("compacthash", time.Now(), &err)
+	return d.compactRanges(hashRanges(name)...)
+}
+
+// CompactZset compact the items, the score index and the size of the zset name
+func (d *DB) CompactZset(name Bytes) (err error) {
+	defer d.metrics.observe("compactzset", time.Now(), &err)
+	return d.compactRanges(zsetRanges(name)...)
+}
+
+// CompactQueue compact the items, the pointers and the size of the queue name
+func (d *DB) CompactQueue(name Bytes) (err error) {
+	defer d.metrics.observe("compactqueue", time.Now(), &err)
+	return d.compactRanges(queueRanges(name)...)
+}
+
+// CompactExpired compact the expire stamps already due and the exkv keys,
+// where the expire daemon left its deletions
+func (d *DB) CompactExpired() (err error) {
+	defer d.metrics.observe("compactexpired", time.Now(), &err)
+	return d.compactRanges(expiredRanges(uint64(time.Now().Unix()))...)
+}
+
+func kvRange(start, end Bytes) (ret util.Range) {
+	ret = util.Range{Start: encodeKvKey(start), Limit: encodeKvKey(end)}
+	if len(end) == 0 {
+		ret.Limit = encodeOneKey(DTKV+1, nil)
+	}
+	return ret
+}
+
+func hashRanges(name Bytes) (ret []util.Range) {
+	return []util.Range{
+		{Start: encodeTwoKey(DTHASH, name, 0, nil), Limit: encodeTwoKey(DTHASH, name, 1, nil)},
+		keyOnlyRange(encodeHsizeKey(name))}
+}
+
+func zsetRanges(name Bytes) (ret []util.Range) {
+	return []util.Range{
+		{Start: encodeTwoKey(DTZSET, name, 0, nil), Limit: encodeTwoKey(DTZSET, name, 1, nil)},
+		{Start: encodeTwoKey(DTZSCORE, name, 0, nil), Limit: encodeTwoKey(DTZSCORE, name, 1, nil)},
+		keyOnlyRange(encodeZsizeKey(name))}
+}
+
+func queueRanges(name Bytes) (ret []util.Range) {
+	return []util.Range{
+		{Start: encodeQitemiteraKey(name, 0), Limit: encodeQitemiteraKey(name, 1)},
+		keyOnlyRange(encodeQsizeKey(name))}
+}
+
+// expiredRanges the ranges of the stamps due at now and of the exkv keys
+func expiredRanges(now uint64) (ret []util.Range) {
+	return []util.Range{
+		{Start: encodeExstampKey(nil, 0), Limit: encodeExstampKey(nil, now+1)},
+		{Start: encodeOneKey(DTEXKV, nil), Limit: encodeOneKey(DTEXKV+1, nil)}}
+}
+
+func (d *DB) compactRanges(ranges ...util.Range) (err error) {
+	if d.readOnly {
+		return ErrReadOnly
+	}
+	for _, r := range ranges {
+		if err = d.db.CompactRange(d.rawRange(r)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// keyOnlyRange the range of the key alone
+func keyOnlyRange(key Bytes) (ret util.Range) {
+	limit := make(Bytes, len(key)+1)
+	copy(limit, key)
+	return util.Range{Start: key, Limit: limit}
+}
+
+// KeyBounds the first and the last key of a data type, nil when it has none
+type KeyBounds struct {
+	First Bytes
+	Last  Bytes
+}
+
+// KeyRange the key bounds of each data type, the containers are bounded by their names
+type KeyRange struct {
+	KV    KeyBounds
+	Exkv  KeyBounds // the expiring keys
+	Hash  KeyBounds
+	Zset  KeyBounds
+	Queue KeyBounds
+}
+
+// KeyRange return the first and the last key of each data type
+func (v *view) KeyRange() (ret *KeyRange) {
+	defer v.metrics.observe("keyrange", time.Now(), nil)
+	return &KeyRange{
+		KV:    v.keyBounds(DTKV, decodeKvKey),
+		Exkv:  v.keyBounds(DTEXKV, decodeExkvKey),
+		Hash:  v.keyBounds(DTHSIZE, decodeHsizeKey),
+		Zset:  v.keyBounds(DTZSIZE, decodeZsizeKey),
+		Queue: v.keyBounds(DTQSIZE, decodeQsizeKey),
+	}
+}
+
+// keyBounds the first and the last key of the prefix dt, decoded by decode
+func (v *view) keyBounds(dt byte, decode func(slice Bytes) Bytes) (ret KeyBounds) {
+	start, end := encodeOneKey(dt, nil), encodeOneKey(dt+1, nil)
+	it := v.Iterator(start, end)
+	if it.Next() {
+		ret.First = decode(it.Key())
+	}
+	it.Close()
+	it = v.RevIterator(start, end)
+	if it.Next() {
+		ret.Last = decode(it.Key())
+	}
+	it.Close()
+	return ret
 }
 
 //
@@ -239,14 +345,22 @@ func (d *DB) KeyRange(keys []string) {
 //
 //	// repl: whether to sync d operation to slaves
 func (d *DB) RawSet(key Bytes, val Bytes) (err error) {
-	//var writeOpts opt.WriteOptions
-	return d.db.Put(key, val, nil)
+	defer d.metrics.observe("rawset", time.Now(), &err)
+	// a raw key may be of any container, all the writers are excluded
+	return d.updateAll(func(tx *Tx) error {
+		tx.put(key, val)
+		return nil
+	})
 }
 func (d *DB) RawDel(key Bytes) (err error) {
-	//var writeOpts opt.WriteOptions
-	return d.db.Delete(key, nil)
+	defer d.metrics.observe("rawdel", time.Now(), &err)
+	return d.updateAll(func(tx *Tx) error {
+		tx.delete(key)
+		return nil
+	})
 }
-func (d *DB) RawGet(key Bytes) (val Bytes, err error) {
+func (v *view) RawGet(key Bytes) (val Bytes, err error) {
+	defer v.metrics.observe("rawget", time.Now(), &err)
 	//var writeOpts opt.WriteOptions
-	return d.db.Get(key, nil)
+	return v.r.Get(key, nil)
 }
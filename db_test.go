@@ -0,0 +1,157 @@
+package emssdb
+
+import (
+	"bytes"
+	"github.com/syndtr/goleveldb/leveldb/util"
+	"strings"
+	"testing"
+	"time"
+)
+
+// openTestDB open a db in a temporary directory, closed at the end of the test
+func openTestDB(t *testing.T, options Options) (ret *DB) {
+	t.Helper()
+	if options.Path == "" {
+		options.Path = t.TempDir()
+	}
+	d, err := OpenDB(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(d.Close)
+	return d
+}
+
+// checkClean fail the test if Check finds mismatches in d
+func checkClean(t *testing.T, d *DB) {
+	t.Helper()
+	rep, err := d.Check()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Problems != 0 {
+		t.Fatalf("check: %+v", rep.Issues)
+	}
+}
+
+func TestMaxKeyLenReopen(t *testing.T) {
+	path := t.TempDir()
+	long := Bytes(strings.Repeat("k", 300))
+	d, err := OpenDB(Options{Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Hset(long, long, Bytes("v"))
+	d.Hset(long, Bytes("f"), Bytes("v"))
+	d.Zset(long, long, 1)
+	d.QpushBack(long, Bytes("a"))
+	d.Close()
+
+	d = openTestDB(t, Options{Path: path, MaxKeyLen: 100})
+	if val, err := d.Hget(long, long); err != nil || string(val) != "v" {
+		t.Fatal(val, err)
+	}
+	if err := d.Hset(long, Bytes("g"), Bytes("v")); err != ErrLongKey {
+		t.Fatal("hset", err)
+	}
+	if err := d.Hdel(long, long); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Zdel(long, long); err != nil {
+		t.Fatal(err)
+	}
+	if item, err := d.QpopFront(long); err != nil || string(item) != "a" {
+		t.Fatal(item, err)
+	}
+	if types := d.Type(long); string(types) != string([]byte{DTHASH}) {
+		t.Fatalf("types %q", types)
+	}
+	checkClean(t, d)
+}
+
+func TestCloseTwice(t *testing.T) {
+	d, err := OpenDB(Options{Path: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Close()
+	d.Close()
+}
+
+func TestCompactRangeBounds(t *testing.T) {
+	d := openTestDB(t, Options{})
+	ns, _ := d.Namespace("a")
+	now := uint64(time.Now().Unix())
+	// the containers named a, and their neighbours in the key order
+	for _, h := range []*DB{d, ns} {
+		for _, name := range []string{"`", "a", "a\x00", "a\x01", "ab", "b"} {
+			k := Bytes(name)
+			h.Set(k, Bytes("v"))
+			h.Set(append(Bytes("c"), k...), Bytes("v"))
+			h.Hset(k, k, Bytes("v"))
+			h.Zset(k, k, 1)
+			h.QpushBack(k, k)
+			h.Eset(k, Bytes("v"), now+3600)
+		}
+	}
+
+	twoKey := func(name string, dts ...byte) func(key Bytes) bool {
+		return func(key Bytes) bool {
+			kname, _ := decodeTwoKey(key)
+			return bytes.IndexByte(dts, key[0]) >= 0 && string(kname) == name
+		}
+	}
+	cases := []struct {
+		ranges []util.Range
+		want   func(key Bytes) bool // whether the key, without its namespace, is in the ranges
+	}{
+		{[]util.Range{kvRange(Bytes("a"), Bytes("ab"))}, func(key Bytes) bool {
+			return key[0] == DTKV && string(key[1:]) >= "a" && string(key[1:]) < "ab"
+		}},
+		{[]util.Range{kvRange(Bytes("b"), nil)}, func(key Bytes) bool {
+			return key[0] == DTKV && string(key[1:]) >= "b"
+		}},
+		{hashRanges(Bytes("a")), func(key Bytes) bool {
+			return twoKey("a", DTHASH)(key) || bytes.Equal(key, encodeHsizeKey(Bytes("a")))
+		}},
+		{zsetRanges(Bytes("a")), func(key Bytes) bool {
+			return twoKey("a", DTZSET, DTZSCORE)(key) || bytes.Equal(key, encodeZsizeKey(Bytes("a")))
+		}},
+		{queueRanges(Bytes("a")), func(key Bytes) bool {
+			return twoKey("a", DTQUEUE)(key) || bytes.Equal(key, encodeQsizeKey(Bytes("a")))
+		}},
+		{expiredRanges(now + 3600), func(key Bytes) bool {
+			return key[0] == DTEXKV || key[0] == DTEXSTAMP
+		}},
+		{expiredRanges(now + 3599), func(key Bytes) bool {
+			return key[0] == DTEXKV
+		}},
+	}
+	for i, c := range cases {
+		for _, h := range []*DB{d, ns} {
+			raws := make([]util.Range, len(c.ranges))
+			for j, r := range c.ranges {
+				raws[j] = h.rawRange(r)
+			}
+			it := d.db.NewIterator(nil, nil)
+			for it.Next() {
+				key := it.Key()
+				in := false
+				for _, r := range raws {
+					in = in || bytes.Compare(key, r.Start) >= 0 && bytes.Compare(key, r.Limit) < 0
+				}
+				// the key seen by h
+				var hkey Bytes
+				if h == d && key[0] != DTNAMESPACE {
+					hkey = key
+				} else if name, nkey := decodeNamespaceKey(key); h == ns && key[0] == DTNAMESPACE && string(name) == "a" {
+					hkey = nkey
+				}
+				if want := len(hkey) > 0 && c.want(hkey); in != want {
+					t.Errorf("case %d, namespace %q: key %q in the ranges %v", i, h.namespace, key, in)
+				}
+			}
+			it.Release()
+		}
+	}
+}
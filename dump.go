@@ -0,0 +1,377 @@
+package emssdb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"time"
+)
+
+// The dump is a stream of logical records, independent of the key layout of the db:
+//
+//	header: [magic "EMSSDUMP"][version byte]
+//	record: [type byte][uvarint len(body)][body][crc32 of type, len and body, big endian]
+//
+// The fields of a body are uvarint length prefixed bytes, and the numbers are varints.
+//
+//	kv:    key, val
+//	exkv:  key, val, uvarint etime
+//	hash:  name, key, val
+//	zset:  name, key, varint score
+//	queue: name, item (from the front to the back)
+//	namespace: name, the records after it up to the next namespace are of the namespace
+//	end:   uvarint count of the records before it
+//
+// The version 1 has no namespace records.
+
+const (
+	dumpMagic   = "EMSSDUMP"
+	dumpVersion = 2
+
+	dumpKV    = 'k'
+	dumpEXKV  = 'x'
+	dumpHash  = 'h'
+	dumpZset  = 's'
+	dumpQueue = 'q'
+	dumpNS    = 'n'
+	dumpEnd   = 'E'
+
+	dumpMaxBody = 1 << 30
+	loadBatch   = 1000 // records written by each transaction of Load
+)
+
+type dumpWriter struct {
+	w     *bufio.Writer
+	body  []byte
+	count uint64
+}
+
+func (dw *dumpWriter) uvarint(x uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	dw.body = append(dw.body, buf[:binary.PutUvarint(buf[:], x)]...)
+}
+
+func (dw *dumpWriter) varint(x int64) {
+	var buf [binary.MaxVarintLen64]byte
+	dw.body = append(dw.body, buf[:binary.PutVarint(buf[:], x)]...)
+}
+
+func (dw *dumpWriter) bytes(field Bytes) {
+	dw.uvarint(uint64(len(field)))
+	dw.body = append(dw.body, field...)
+}
+
+func (dw *dumpWriter) record(typ byte) (err error) {
+	var head [1 + binary.MaxVarintLen64]byte
+	head[0] = typ
+	n := 1 + binary.PutUvarint(head[1:], uint64(len(dw.body)))
+	crc := crc32.NewIEEE()
+	crc.Write(head[:n])
+	crc.Write(dw.body)
+	var sum [4]byte
+	binary.BigEndian.PutUint32(sum[:], crc.Sum32())
+	if _, err = dw.w.Write(head[:n]); err != nil {
+		return err
+	}
+	if _, err = dw.w.Write(dw.body); err != nil {
+		return err
+	}
+	_, err = dw.w.Write(sum[:])
+	dw.body = dw.body[:0]
+	dw.count++
+	return err
+}
+
+// Dump write all the data of a snapshot of the db to w, see Load.
+// The dump of a DB has the data of all its namespaces, the dump of a namespace has its own.
+func (d *DB) Dump(w io.Writer) (err error) {
+	defer d.metrics.observe("dump", time.Now(), &err)
+	s, err := d.snapshot()
+	if err != nil {
+		return err
+	}
+	defer s.Release()
+
+	dw := &dumpWriter{w: bufio.NewWriter(w)}
+	if _, err = dw.w.WriteString(dumpMagic); err != nil {
+		return err
+	}
+	if err = dw.w.WriteByte(dumpVersion); err != nil {
+		return err
+	}
+	if err = dumpSnapshot(s, dw); err != nil {
+		return err
+	}
+	if d.root == nil {
+		for _, name := range namespaceNames(s.snap) {
+			dw.bytes(Bytes(name))
+			if err = dw.record(dumpNS); err != nil {
+				return err
+			}
+			// the snapshot of the namespace, read as the handle of the namespace does
+			ns := &Snapshot{snap: s.snap}
+			ns.r = &nsReader{reader: s.r, prefix: encodeNamespacePrefix(Bytes(name)), limit: encodeNamespaceLimit(Bytes(name))}
+			ns.maxKeyLen = s.maxKeyLen
+			if err = dumpSnapshot(ns, dw); err != nil {
+				return err
+			}
+		}
+	}
+	dw.uvarint(dw.count)
+	if err = dw.record(dumpEnd); err != nil {
+		return err
+	}
+	return dw.w.Flush()
+}
+
+// dumpSnapshot write the records of all the data types of s
+func dumpSnapshot(s *Snapshot, dw *dumpWriter) (err error) {
+	for _, dump := range []func(*Snapshot, *dumpWriter) error{dumpKVs, dumpEXKVs, dumpHashes, dumpZsets, dumpQueues} {
+		if err = dump(s, dw); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func dumpKVs(s *Snapshot, dw *dumpWriter) (err error) {
+	it := s.Scan(nil, nil)
+	defer it.Close()
+	for it.Next() {
+		dw.bytes(it.Key())
+		dw.bytes(it.Value())
+		if err = dw.record(dumpKV); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func dumpEXKVs(s *Snapshot, dw *dumpWriter) (err error) {
+	it := s.Escan(nil, nil)
+	defer it.Close()
+	for it.Next() {
+		dw.bytes(it.Key())
+		dw.bytes(it.Value())
+		dw.uvarint(it.Etime())
+		if err = dw.record(dumpEXKV); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func dumpHashes(s *Snapshot, dw *dumpWriter) (err error) {
+	for _, name := range s.Hlist(nil, nil) {
+		it := s.Hscan(name, nil, nil)
+		for it.Next() {
+			dw.bytes(name)
+			dw.bytes(it.Key())
+			dw.bytes(it.Value())
+			if err = dw.record(dumpHash); err != nil {
+				break
+			}
+		}
+		it.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func dumpZsets(s *Snapshot, dw *dumpWriter) (err error) {
+	for _, name := range s.Zlist(nil, nil) {
+		// the key => score index, it covers all the scores
+		it := s.Iterator(encodeZsetKey(name, nil), encodeTwoKey(DTZSET, name, 1, nil))
+		for it.Next() {
+			_, key := decodeZsetKey(it.Key())
+			dw.bytes(name)
+			dw.bytes(key)
+			dw.varint(it.Value().GetInt64())
+			if err = dw.record(dumpZset); err != nil {
+				break
+			}
+		}
+		it.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func dumpQueues(s *Snapshot, dw *dumpWriter) (err error) {
+	for _, name := range s.Qlist(nil, nil) {
+		// the front item has the largest seq
+		it := NewQIterator(s.RevIterator(encodeQitemKey(name, 0), encodeQitemKey(name, 0x7FFFFFFFffffffff)))
+		for it.Next() {
+			dw.bytes(name)
+			dw.bytes(it.Value())
+			if err = dw.record(dumpQueue); err != nil {
+				break
+			}
+		}
+		it.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type dumpReader struct {
+	r    *bufio.Reader
+	body []byte
+}
+
+// next read a record and check its crc
+func (dr *dumpReader) next() (typ byte, err error) {
+	if typ, err = dr.r.ReadByte(); err != nil {
+		if err == io.EOF {
+			return 0, ErrBadDump
+		}
+		return 0, err
+	}
+	length, err := binary.ReadUvarint(dr.r)
+	if err != nil || length > dumpMaxBody {
+		return 0, ErrBadDump
+	}
+	var head [1 + binary.MaxVarintLen64]byte
+	head[0] = typ
+	crc := crc32.NewIEEE()
+	crc.Write(head[:1+binary.PutUvarint(head[1:], length)])
+	dr.body = make([]byte, length+4)
+	if _, err = io.ReadFull(dr.r, dr.body); err != nil {
+		return 0, ErrBadDump
+	}
+	crc.Write(dr.body[:length])
+	if crc.Sum32() != binary.BigEndian.Uint32(dr.body[length:]) {
+		return 0, ErrBadDump
+	}
+	dr.body = dr.body[:length]
+	return typ, nil
+}
+
+func (dr *dumpReader) bytes() (ret Bytes, err error) {
+	length, n := binary.Uvarint(dr.body)
+	if n <= 0 || length > uint64(len(dr.body)-n) {
+		return nil, ErrBadDump
+	}
+	ret = dr.body[n : n+int(length)]
+	dr.body = dr.body[n+int(length):]
+	return ret, nil
+}
+
+func (dr *dumpReader) uvarint() (ret uint64, err error) {
+	ret, n := binary.Uvarint(dr.body)
+	if n <= 0 {
+		return 0, ErrBadDump
+	}
+	dr.body = dr.body[n:]
+	return ret, nil
+}
+
+func (dr *dumpReader) varint() (ret int64, err error) {
+	ret, n := binary.Varint(dr.body)
+	if n <= 0 {
+		return 0, ErrBadDump
+	}
+	dr.body = dr.body[n:]
+	return ret, nil
+}
+
+// Load write the records of a dump made by Dump into the db.
+// The records overwrite the existing keys, and the queue items are pushed to the back.
+// The records are committed by batches, a bad dump stops Load at the first bad record.
+// The records of a namespace are loaded into the namespace of the same name,
+// a namespace is loaded by a DB only.
+func (d *DB) Load(r io.Reader) (err error) {
+	defer d.metrics.observe("load", time.Now(), &err)
+	dr := &dumpReader{r: bufio.NewReader(r)}
+	head := make([]byte, len(dumpMagic)+1)
+	if _, err = io.ReadFull(dr.r, head); err != nil || string(head[:len(dumpMagic)]) != dumpMagic {
+		return ErrBadDump
+	}
+	if head[len(dumpMagic)] > dumpVersion {
+		return ErrDumpVersion
+	}
+
+	var count uint64
+	h := d // the handle loaded, d or a namespace of it
+	for end := false; !end; {
+		err = h.updateAll(func(tx *Tx) (err error) {
+			for i := 0; i < loadBatch; i++ {
+				typ, err := dr.next()
+				if err != nil {
+					return err
+				}
+				if typ == dumpEnd {
+					end = true
+					if n, err := dr.uvarint(); err != nil || n != count {
+						return ErrBadDump
+					}
+					return nil
+				}
+				if typ == dumpNS {
+					// commit the batch, the next one is of the namespace
+					name, err := dr.bytes()
+					if err != nil || d.root != nil {
+						return ErrBadDump
+					}
+					if h, err = d.Namespace(string(name)); err != nil {
+						return err
+					}
+					count++
+					return nil
+				}
+				if err = dr.load(tx, typ); err != nil {
+					return err
+				}
+				count++
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dumpFields is the number of the bytes fields of each record type
+var dumpFields = map[byte]int{dumpKV: 2, dumpEXKV: 2, dumpHash: 3, dumpZset: 2, dumpQueue: 2}
+
+func (dr *dumpReader) load(tx *Tx, typ byte) (err error) {
+	n, ok := dumpFields[typ]
+	if !ok {
+		return ErrBadDump
+	}
+	f := make([]Bytes, n)
+	for i := range f {
+		if f[i], err = dr.bytes(); err != nil {
+			return err
+		}
+	}
+	switch typ {
+	case dumpKV:
+		return tx.Set(f[0], f[1])
+	case dumpEXKV:
+		etime, err := dr.uvarint()
+		if err != nil {
+			return err
+		}
+		return tx.Eset(f[0], f[1], etime)
+	case dumpHash:
+		return tx.Hset(f[0], f[1], f[2])
+	case dumpZset:
+		score, err := dr.varint()
+		if err != nil {
+			return err
+		}
+		return tx.Zset(f[0], f[1], score)
+	default:
+		return tx.QpushBack(f[0], f[1])
+	}
+}
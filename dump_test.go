@@ -0,0 +1,135 @@
+package emssdb
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// fillTestData write some data of every data type into h
+func fillTestData(h *DB, tag string) {
+	etime := uint64(time.Now().Add(time.Hour).UnixNano() / 1e6)
+	for i := 0; i < 3; i++ {
+		k := Bytes(tag + string(rune('a'+i)))
+		h.Set(k, Bytes("v"+tag))
+		h.Eset(append(Bytes("x"), k...), Bytes("e"+tag), etime)
+		h.Hset(Bytes("h"+tag), k, Bytes("hv"))
+		h.Zset(Bytes("z"+tag), k, int64(i-1))
+		h.QpushBack(Bytes("q"+tag), k)
+	}
+}
+
+func dumpBytes(t *testing.T, d *DB) (ret []byte) {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := d.Dump(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDumpLoadNamespaces(t *testing.T) {
+	d := openTestDB(t, Options{})
+	fillTestData(d, "root")
+	for _, name := range []string{"ns1", "ns\x002"} {
+		ns, _ := d.Namespace(name)
+		fillTestData(ns, name)
+	}
+	dump := dumpBytes(t, d)
+
+	d2 := openTestDB(t, Options{})
+	if err := d2.Load(bytes.NewReader(dump)); err != nil {
+		t.Fatal(err)
+	}
+	if got := d2.Namespaces(); len(got) != 2 {
+		t.Fatal("namespaces", got)
+	}
+	ns, _ := d2.Namespace("ns\x002")
+	if val, err := ns.Hget(Bytes("hns\x002"), Bytes("ns\x002b")); err != nil || string(val) != "hv" {
+		t.Fatal(val, err)
+	}
+	if !bytes.Equal(dumpBytes(t, d2), dump) {
+		t.Fatal("the dump of the loaded db differs")
+	}
+	checkClean(t, d2)
+
+	// the dump of a namespace has its data only, and a namespace does not load the namespaces
+	ns1, _ := d.Namespace("ns1")
+	d3 := openTestDB(t, Options{})
+	if err := d3.Load(bytes.NewReader(dumpBytes(t, ns1))); err != nil {
+		t.Fatal(err)
+	}
+	if val, err := d3.Get(Bytes("ns1a")); err != nil || string(val) != "vns1" || len(d3.Namespaces()) != 0 {
+		t.Fatal(val, err, d3.Namespaces())
+	}
+	into, _ := d3.Namespace("into")
+	if err := into.Load(bytes.NewReader(dump)); err != ErrBadDump {
+		t.Fatal(err)
+	}
+}
+
+func TestDumpLoad(t *testing.T) {
+	d := openTestDB(t, Options{})
+	fillTestData(d, "t")
+	// more records than a batch of Load
+	for i := 0; i < 2*loadBatch; i++ {
+		d.Hset(Bytes("big"), Bytes(fmt.Sprint(i)), Bytes("v"))
+	}
+	dump := dumpBytes(t, d)
+
+	d2 := openTestDB(t, Options{})
+	d2.Set(Bytes("ta"), Bytes("old"))
+	if err := d2.Load(bytes.NewReader(dump)); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(dumpBytes(t, d2), dump) {
+		t.Fatal("the dump of the loaded db differs")
+	}
+	if size, err := d2.Hsize(Bytes("big")); err != nil || size != 2*loadBatch {
+		t.Fatal(size, err)
+	}
+	checkClean(t, d2)
+}
+
+func TestLoadBadDump(t *testing.T) {
+	d := openTestDB(t, Options{})
+	fillTestData(d, "t")
+	dump := dumpBytes(t, d)
+	d2 := openTestDB(t, Options{})
+
+	// a flipped byte fails the crc of its record
+	for i := range dump {
+		bad := append([]byte(nil), dump...)
+		bad[i] ^= 0xff
+		want := ErrBadDump
+		if i == len(dumpMagic) {
+			want = ErrDumpVersion
+		}
+		if err := d2.Load(bytes.NewReader(bad)); err != want {
+			t.Fatalf("byte %d: %v", i, err)
+		}
+	}
+	// a truncated dump misses its end record
+	for i := 0; i < len(dump); i++ {
+		if err := d2.Load(bytes.NewReader(dump[:i])); err != ErrBadDump {
+			t.Fatalf("length %d: %v", i, err)
+		}
+	}
+
+	// an end record with a wrong count
+	var buf bytes.Buffer
+	dw := &dumpWriter{w: bufio.NewWriter(&buf)}
+	dw.w.WriteString(dumpMagic)
+	dw.w.WriteByte(dumpVersion)
+	dw.bytes(Bytes("k"))
+	dw.bytes(Bytes("v"))
+	dw.record(dumpKV)
+	dw.uvarint(2)
+	dw.record(dumpEnd)
+	dw.w.Flush()
+	if err := d2.Load(&buf); err != ErrBadDump {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,197 @@
+package emssdb
+
+import (
+	"encoding/binary"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"strconv"
+	"time"
+)
+
+// The key formats of the data directory of ssdb:
+//
+//	kv:     ['k'][key] => val
+//	hash:   ['h'][len(name)][name]['='][key] => val
+//	zset:   ['s'][len(name)][name][len(key)][key] => score as a decimal string
+//	queue:  ['q'][len(name)][name][seq, uint64 big endian] => item, the items are at seq >= 10000
+//	hsize ['H'], zsize ['Z'], zscore ['z'] and qsize ['Q'] are the indexes, emssdb rebuilds its own.
+//
+// The ttl of the kv are in the zset ssdbExpireList, key => expire time in ms.
+
+const (
+	ssdbExpireList  = "\xff\xff\xff\xff\xff|EXPIRE_LIST|KV"
+	ssdbQitemMinSeq = 10000
+	ssdbMaxBadKeys  = 1000
+)
+
+// ImportReport the result of ImportSSDB
+type ImportReport struct {
+	KV      int64   // kv without ttl, written by Set
+	Expire  int64   // kv with ttl, written by Eset
+	Expired int64   // kv whose ttl has passed, skipped
+	Hash    int64   // hash fields, written by Hset
+	Zset    int64   // zset members, written by Zset
+	Queue   int64   // queue items, written by QpushBack
+	Index   int64   // size, score, queue pointer and ttl keys, skipped
+	Bad     int64   // keys not decodable or not accepted by emssdb, skipped
+	BadKeys []Bytes // the first ssdbMaxBadKeys of the bad keys
+}
+
+func (rep *ImportReport) bad(key Bytes) {
+	rep.Bad++
+	if len(rep.BadKeys) < ssdbMaxBadKeys {
+		rep.BadKeys = append(rep.BadKeys, NewByClone(key))
+	}
+}
+
+// ImportSSDB write the data of the ssdb data directory dir into the db.
+// The directory is opened read only, the ssdb server should be stopped.
+func (d *DB) ImportSSDB(dir string) (ret *ImportReport, err error) {
+	defer d.metrics.observe("importssdb", time.Now(), &err)
+	src, err := leveldb.OpenFile(dir, &opt.Options{ErrorIfMissing: true, ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	rep := &ImportReport{}
+	it := src.NewIterator(nil, &opt.ReadOptions{DontFillCache: true})
+	defer it.Release()
+	now := time.Now().Unix()
+	for more := true; more; {
+		err = d.updateAll(func(tx *Tx) (err error) {
+			for i := 0; i < loadBatch; i++ {
+				if more = it.Next(); !more {
+					return it.Error()
+				}
+				if err = rep.importKey(tx, src, now, it.Key(), it.Value()); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return rep, err
+		}
+	}
+	return rep, nil
+}
+
+func (rep *ImportReport) importKey(tx *Tx, src *leveldb.DB, now int64, key, val Bytes) (err error) {
+	if len(key) == 0 {
+		rep.bad(key)
+		return nil
+	}
+	var count *int64
+	switch key[0] {
+	case 'k':
+		k := key[1:]
+		if ms, ok := ssdbTTL(src, k); !ok {
+			count, err = &rep.KV, tx.Set(k, val)
+		} else if etime := (ms + 999) / 1000; etime <= now {
+			count = &rep.Expired
+		} else {
+			count, err = &rep.Expire, tx.Eset(k, val, uint64(etime))
+		}
+	case 'h':
+		name, hkey, ok := decodeSSDBHashKey(key)
+		if !ok {
+			rep.bad(key)
+			return nil
+		}
+		count, err = &rep.Hash, tx.Hset(name, hkey, val)
+	case 's':
+		name, zkey, ok := decodeSSDBZsetKey(key)
+		if !ok {
+			rep.bad(key)
+			return nil
+		}
+		if string(name) == ssdbExpireList {
+			count = &rep.Index
+			break
+		}
+		score, perr := strconv.ParseInt(string(val), 10, 64)
+		if perr != nil {
+			rep.bad(key)
+			return nil
+		}
+		count, err = &rep.Zset, tx.Zset(name, zkey, score)
+	case 'q':
+		name, seq, ok := decodeSSDBQitemKey(key)
+		if !ok {
+			rep.bad(key)
+			return nil
+		}
+		if seq < ssdbQitemMinSeq {
+			count = &rep.Index
+			break
+		}
+		count, err = &rep.Queue, tx.QpushBack(name, val)
+	case 'H', 'Z', 'z', 'Q':
+		count = &rep.Index
+	default:
+		rep.bad(key)
+		return nil
+	}
+	if err == ErrEmptyKey || err == ErrLongKey {
+		rep.bad(key)
+		return nil
+	} else if err != nil {
+		return err
+	}
+	*count++
+	return nil
+}
+
+// ssdbTTL return the expire time in ms of the kv key
+func ssdbTTL(src *leveldb.DB, key Bytes) (ms int64, ok bool) {
+	if len(key) > SSDB_KEY_LEN_MAX {
+		return 0, false
+	}
+	zkey := make(Bytes, 0, 3+len(ssdbExpireList)+len(key))
+	zkey = append(zkey, 's', byte(len(ssdbExpireList)))
+	zkey = append(zkey, ssdbExpireList...)
+	zkey = append(zkey, byte(len(key)))
+	zkey = append(zkey, key...)
+	val, err := src.Get(zkey, nil)
+	if err != nil {
+		return 0, false
+	}
+	ms, err = strconv.ParseInt(string(val), 10, 64)
+	return ms, err == nil
+}
+
+// ['h'][len(name)][name]['='][key]
+func decodeSSDBHashKey(slice Bytes) (name, key Bytes, ok bool) {
+	if len(slice) < 2 || len(slice) < 2+int(slice[1])+1 {
+		return nil, nil, false
+	}
+	name = slice[2 : 2+int(slice[1])]
+	p := slice[2+len(name):]
+	if p[0] != '=' {
+		return nil, nil, false
+	}
+	return name, p[1:], true
+}
+
+// ['s'][len(name)][name][len(key)][key]
+func decodeSSDBZsetKey(slice Bytes) (name, key Bytes, ok bool) {
+	if len(slice) < 2 || len(slice) < 2+int(slice[1])+1 {
+		return nil, nil, false
+	}
+	name = slice[2 : 2+int(slice[1])]
+	p := slice[2+len(name):]
+	if len(p) != 1+int(p[0]) {
+		return nil, nil, false
+	}
+	return name, p[1:], true
+}
+
+// ['q'][len(name)][name][seq]
+func decodeSSDBQitemKey(slice Bytes) (name Bytes, seq uint64, ok bool) {
+	if len(slice) < 2 || len(slice) != 2+int(slice[1])+8 {
+		return nil, 0, false
+	}
+	name = slice[2 : 2+int(slice[1])]
+	return name, binary.BigEndian.Uint64(slice[2+len(name):]), true
+}
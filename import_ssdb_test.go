@@ -0,0 +1,85 @@
+package emssdb
+
+import (
+	"encoding/binary"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// ssdbKey join the parts of a key of the ssdb layout, a string part is prefixed by its length byte,
+// a []byte part is raw
+func ssdbKey(dt byte, parts ...interface{}) (ret string) {
+	ret = string(dt)
+	for _, p := range parts {
+		switch p := p.(type) {
+		case string:
+			ret += string([]byte{byte(len(p))}) + p
+		case []byte:
+			ret += string(p)
+		case uint64:
+			var seq [8]byte
+			binary.BigEndian.PutUint64(seq[:], p)
+			ret += string(seq[:])
+		}
+	}
+	return ret
+}
+
+func TestImportSSDB(t *testing.T) {
+	dir := t.TempDir()
+	ms := func(d time.Duration) Bytes {
+		return Bytes(strconv.FormatInt(time.Now().Add(d).UnixNano()/1e6, 10))
+	}
+	writeRawDB(t, dir, map[string]Bytes{
+		"kkv":                                 Bytes("v"),
+		"kfresh":                              Bytes("f"),
+		"kstale":                              Bytes("s"),
+		ssdbKey('s', ssdbExpireList, "fresh"): ms(time.Hour),
+		ssdbKey('s', ssdbExpireList, "stale"): ms(-time.Hour),
+		ssdbKey('h', "h", []byte("=f=1")):     Bytes("hv"),
+		ssdbKey('s', "z", "m"):                Bytes("-5"),
+		ssdbKey('q', "q", uint64(10000)):      Bytes("a"),
+		ssdbKey('q', "q", uint64(10001)):      Bytes("b"),
+		ssdbKey('q', "q", uint64(1)):          Bytes("pointer"),
+		ssdbKey('H', "h"):                     Bytes("1"),
+		"z\x01zm":                             Bytes(""),
+		// the bad keys
+		ssdbKey('h', "h", []byte("-f")): Bytes("x"),
+		ssdbKey('h', "", []byte("=f")):  Bytes("x"),
+		ssdbKey('s', "z", "m") + "+":    Bytes("1"),
+		ssdbKey('s', "z", "n"):          Bytes("NaN"),
+		ssdbKey('q', "q") + "short":     Bytes("x"),
+		"w":                             Bytes("x"),
+	})
+
+	d := openTestDB(t, Options{})
+	rep, err := d.ImportSSDB(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.KV != 1 || rep.Expire != 1 || rep.Expired != 1 || rep.Hash != 1 || rep.Zset != 1 || rep.Queue != 2 || rep.Index != 5 || rep.Bad != 6 || len(rep.BadKeys) != 6 {
+		t.Fatalf("%+v", rep)
+	}
+	if val, err := d.Get(Bytes("kv")); err != nil || string(val) != "v" {
+		t.Fatal(val, err)
+	}
+	if val, etime, err := d.Eget(Bytes("fresh")); err != nil || string(val) != "f" || int64(etime) <= time.Now().Unix() {
+		t.Fatal(val, etime, err)
+	}
+	if _, err := d.Get(Bytes("stale")); err != ErrNotFound {
+		t.Fatal(err)
+	}
+	if val, err := d.Hget(Bytes("h"), Bytes("f=1")); err != nil || string(val) != "hv" {
+		t.Fatal(val, err)
+	}
+	if score, err := d.Zget(Bytes("z"), Bytes("m")); err != nil || score != -5 {
+		t.Fatal(score, err)
+	}
+	front, _ := d.Qfront(Bytes("q"))
+	back, _ := d.Qback(Bytes("q"))
+	if size, err := d.Qsize(Bytes("q")); err != nil || size != 2 || string(front) != "a" || string(back) != "b" {
+		t.Fatal(size, err, front, back)
+	}
+	checkClean(t, d)
+}
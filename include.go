@@ -1,7 +1,9 @@
 package emssdb
 
 import (
+	"encoding/binary"
 	"errors"
+	"github.com/syndtr/goleveldb/leveldb"
 )
 
 const (
@@ -19,23 +21,49 @@ const (
 	DTZSIZE          = 'Z'
 	DTQUEUE          = 'q'
 	DTQSIZE          = 'Q'
+	DTVERSION        = 'v' // [DT][name] => version stamp, the epoch of the last commit
+	DTMETA           = 'm' // [name] => information of the db
+	DTNAMESPACE      = 'n' // [uvarint len(name)][name][0][key of a data type]
 	MIN_PREFIX       = DTHASH
 	MAX_PREFIX       = DTZSET
 )
 
 type Status error
 
+// knownPrefix tell whether the raw key is of one of the data types
+func knownPrefix(key Bytes) bool {
+	if len(key) == 0 {
+		return false
+	}
+	switch key[0] {
+	case DTKV, DTEXKV, DTEXSTAMP, DTHASH, DTHSIZE, DTZSET, DTZSCORE, DTZSIZE, DTQUEUE, DTQSIZE, DTVERSION, DTMETA:
+		return true
+	case DTNAMESPACE:
+		name, nkey := decodeNamespaceKey(key)
+		return len(name) > 0 && len(nkey) > 0 && nkey[0] != DTMETA && nkey[0] != DTNAMESPACE && knownPrefix(nkey)
+	}
+	return false
+}
+
 var (
-	ErrNotFound   = errors.New("ssdb: not found")
-	ErrEmptyKey   = errors.New("ssdb: empty key")
-	ErrLongKey    = errors.New("ssdb: key too long")
-	StatSuccess   = errors.New("")
-	StatSucChange = errors.New("ssdb: change size")
-	StatNotFound  = errors.New("ssdb: no item")
-	ErrOptFail    = errors.New("ssdb: operate fail")
-	ErrNotIntVal  = errors.New("ssdb: not intager val")
-	ErrOutOfRange = errors.New("ssdb: out of range")
-	ErrQueue      = errors.New("error queue")
+	ErrNotFound      = leveldb.ErrNotFound // same value the readers return
+	ErrEmptyKey      = errors.New("ssdb: empty key")
+	ErrLongKey       = errors.New("ssdb: key too long")
+	StatSuccess      = errors.New("")
+	StatSucChange    = errors.New("ssdb: change size")
+	StatNotFound     = errors.New("ssdb: no item")
+	ErrOptFail       = errors.New("ssdb: operate fail")
+	ErrNotIntVal     = errors.New("ssdb: not intager val")
+	ErrOutOfRange    = errors.New("ssdb: out of range")
+	ErrQueue         = errors.New("error queue")
+	ErrConflict      = errors.New("ssdb: watched keys changed")
+	ErrReadOnly      = errors.New("ssdb: read only")
+	ErrKeyExists     = errors.New("ssdb: key exists")
+	ErrBadCursor     = errors.New("ssdb: bad cursor")
+	ErrBadBackup     = errors.New("ssdb: not a emssdb backup")
+	ErrBadDump       = errors.New("ssdb: bad dump")
+	ErrDumpVersion   = errors.New("ssdb: dump of a newer version")
+	ErrFormatVersion = errors.New("ssdb: unsupported format version")
 	//ErrSnapshotReleased = errors.New("ssdb: snapshot released")
 	//ErrClosed           = errors.New("ssdb: closed")
 )
@@ -58,15 +86,16 @@ func decodeOneKey(slice Bytes) (key Bytes) {
 	return nil
 }
 
-// [DT][len(NAME)][NAME][0][KEY]
+// [DT][uvarint len(NAME)][NAME][0][KEY]
 func encodeTwoKey(dt byte, name Bytes, seq byte, key Bytes) (ret Bytes) {
-	length := 1 + 2 + len(name) + len(key)
-	buf := make(Bytes, length)
+	var blen [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(blen[:], uint64(len(name)))
+	buf := make(Bytes, 1+n+len(name)+1+len(key))
 	buf[0] = dt
-	//if len(name) > 255 { return nil }
-	buf[1] = byte(len(name))
-	p := buf[2:]
-	copy(buf[2:], name)
+	p := buf[1:]
+	copy(p, blen[:n])
+	p = p[n:]
+	copy(p, name)
 	p = p[len(name):]
 	p[0] = seq
 	p = p[1:]
@@ -78,12 +107,16 @@ func decodeTwoKey(slice Bytes) (keya Bytes, keyb Bytes) {
 	if len(slice) < 3 {
 		return nil, nil
 	}
-	buf := slice[2:]
-	if uint(slice[1]) > uint(len(buf)) {
+	length, n := binary.Uvarint(slice[1:])
+	if n <= 0 {
+		return nil, nil
+	}
+	buf := slice[1+n:]
+	if length >= uint64(len(buf)) {
 		return nil, nil
 	}
-	keya = buf[:slice[1]]
-	keyb = buf[len(keya)+1:]
+	keya = buf[:length]
+	keyb = buf[length+1:]
 	return
 }
 
@@ -91,7 +124,17 @@ func isVaildHashKey(name, key Bytes) (err error) {
 	if len(key) == 0 || len(name) == 0 {
 		return ErrEmptyKey
 	}
-	if len(name) > SSDB_KEY_LEN_MAX || len(key) > SSDB_KEY_LEN_MAX {
+	return nil
+}
+
+// isVaildNewHashKey check the name and the key of a write creating them. Only the
+// writes check Options.MaxKeyLen, so the keys written under a larger one could
+// still be read and deleted.
+func (v *view) isVaildNewHashKey(name, key Bytes) (err error) {
+	if err = isVaildHashKey(name, key); err != nil {
+		return err
+	}
+	if len(name) > v.maxKeyLen || len(key) > v.maxKeyLen {
 		return ErrLongKey
 	}
 	return nil
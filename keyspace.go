@@ -0,0 +1,183 @@
+package emssdb
+
+import "time"
+
+// The keyspace commands see a name in all the data types: the key of kv and exkv,
+// the name of hash, zset and queue. A name could be of several data types at once.
+
+// containerType a data type of containers, its members are the keys
+// [member][uvarint len(name)][name][0][...] of each member prefix
+type containerType struct {
+	dt      byte
+	sizeDT  byte
+	members []byte
+}
+
+var containerTypes = []containerType{
+	{DTHASH, DTHSIZE, []byte{DTHASH}},
+	{DTZSET, DTZSIZE, []byte{DTZSET, DTZSCORE}},
+	{DTQUEUE, DTQSIZE, []byte{DTQUEUE}},
+}
+
+// Type return the data types of name, of DTKV, DTEXKV, DTHASH, DTZSET and DTQUEUE
+func (v *view) Type(name Bytes) (ret []byte) {
+	defer v.metrics.observe("type", time.Now(), nil)
+	return v.types(name)
+}
+
+func (v *view) types(name Bytes) (ret []byte) {
+	if _, err := v.r.Get(encodeKvKey(name), nil); err == nil {
+		ret = append(ret, DTKV)
+	}
+	if _, err := v.r.Get(encodeExkvKey(name), nil); err == nil {
+		ret = append(ret, DTEXKV)
+	}
+	for _, ct := range containerTypes {
+		if _, err := v.r.Get(encodeOneKey(ct.sizeDT, name), nil); err == nil {
+			ret = append(ret, ct.dt)
+		}
+	}
+	return ret
+}
+
+// Exists tell whether name is of any data type
+func (v *view) Exists(name Bytes) (ret bool) {
+	defer v.metrics.observe("exists", time.Now(), nil)
+	return len(v.types(name)) > 0
+}
+
+// Rename move name of all its data types to newname, atomically.
+// It returns ErrNotFound if name is of no data type, ErrKeyExists if newname is of any.
+func (db *DB) Rename(name, newname Bytes) (err error) {
+	defer db.metrics.observe("rename", time.Now(), &err)
+	return db.updateAll(func(tx *Tx) error {
+		return tx.Rename(name, newname)
+	})
+}
+
+// Copy copy src of all its data types to dst, atomically.
+// It returns ErrNotFound if src is of no data type, ErrKeyExists if dst is of any.
+func (db *DB) Copy(src, dst Bytes) (err error) {
+	defer db.metrics.observe("copy", time.Now(), &err)
+	return db.updateAll(func(tx *Tx) error {
+		return tx.Copy(src, dst)
+	})
+}
+
+// DeleteAny delete name of all its data types, atomically, and return the number of the data types
+func (db *DB) DeleteAny(name Bytes) (ret int, err error) {
+	defer db.metrics.observe("deleteany", time.Now(), &err)
+	err = db.updateAll(func(tx *Tx) (err error) {
+		ret, err = tx.DeleteAny(name)
+		return err
+	})
+	return ret, err
+}
+
+// Rename of a Tx sees the members written before in the Tx, as its other point reads
+func (tx *Tx) Rename(name, newname Bytes) (err error) {
+	return tx.copyAny(name, newname, true)
+}
+
+func (tx *Tx) Copy(src, dst Bytes) (err error) {
+	return tx.copyAny(src, dst, false)
+}
+
+func (tx *Tx) DeleteAny(name Bytes) (ret int, err error) {
+	types := tx.types(name)
+	for _, dt := range types {
+		switch dt {
+		case DTKV:
+			tx.Del(name)
+		case DTEXKV:
+			tx.Edel(name)
+		default:
+			tx.deleteContainer(containerTypeOf(dt), name)
+		}
+	}
+	return len(types), nil
+}
+
+func containerTypeOf(dt byte) (ret containerType) {
+	for _, ct := range containerTypes {
+		if ct.dt == dt {
+			return ct
+		}
+	}
+	return ret
+}
+
+func (tx *Tx) copyAny(src, dst Bytes, move bool) (err error) {
+	if len(src) == 0 || len(dst) == 0 {
+		return ErrEmptyKey
+	}
+	if len(dst) > tx.maxKeyLen {
+		return ErrLongKey
+	}
+	types := tx.types(src)
+	if len(types) == 0 {
+		return ErrNotFound
+	}
+	if len(tx.types(dst)) > 0 {
+		return ErrKeyExists
+	}
+	for _, dt := range types {
+		switch dt {
+		case DTKV:
+			val, _ := tx.Get(src)
+			tx.Set(dst, val)
+			if move {
+				tx.Del(src)
+			}
+		case DTEXKV:
+			val, etime, _ := tx.Eget(src)
+			tx.Eset(dst, val, etime)
+			if move {
+				tx.Edel(src)
+			}
+		default:
+			ct := containerTypeOf(dt)
+			tx.copyContainer(ct, src, dst)
+			if move {
+				tx.deleteContainer(ct, src)
+			}
+		}
+	}
+	return nil
+}
+
+// containerMembers return the keys of the member prefix of the container name with their values
+func (tx *Tx) containerMembers(member byte, name Bytes) (ret map[string]Bytes) {
+	ret = make(map[string]Bytes)
+	it := tx.Iterator(encodeTwoKey(member, name, 0, nil), encodeTwoKey(member, name, 1, nil))
+	for it.Next() {
+		ret[string(it.Key())] = it.Value()
+	}
+	it.Close()
+	return ret
+}
+
+// copyContainer write the members and the size of the container src to dst
+func (tx *Tx) copyContainer(ct containerType, src, dst Bytes) {
+	tx.touch(ct.dt, dst)
+	for _, member := range ct.members {
+		for key, val := range tx.containerMembers(member, src) {
+			_, rest := decodeTwoKey(Bytes(key))
+			tx.put(encodeTwoKey(member, dst, 0, rest), val)
+		}
+	}
+	if size, err := tx.r.Get(encodeOneKey(ct.sizeDT, src), nil); err == nil {
+		tx.put(encodeOneKey(ct.sizeDT, dst), size)
+	}
+}
+
+// deleteContainer delete the members and the size of the container name
+func (tx *Tx) deleteContainer(ct containerType, name Bytes) {
+	tx.touch(ct.dt, name)
+	for _, member := range ct.members {
+		for key := range tx.containerMembers(member, name) {
+			tx.delete(Bytes(key))
+		}
+	}
+	tx.delete(encodeOneKey(ct.sizeDT, name))
+}
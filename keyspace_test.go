@@ -0,0 +1,79 @@
+package emssdb
+
+import "testing"
+
+func TestRenameInTx(t *testing.T) {
+	d := openTestDB(t, Options{})
+	d.Hset(Bytes("h"), Bytes("a"), Bytes("1"))
+	d.Zset(Bytes("z"), Bytes("a"), 1)
+	d.QpushBack(Bytes("q"), Bytes("a"))
+
+	err := d.Update(func(tx *Tx) error {
+		tx.Hset(Bytes("h"), Bytes("f"), Bytes("v"))
+		tx.Hdel(Bytes("h"), Bytes("a"))
+		tx.Zset(Bytes("z"), Bytes("b"), 2)
+		tx.QpushBack(Bytes("q"), Bytes("b"))
+		for _, name := range []string{"h", "z", "q"} {
+			if err := tx.Rename(Bytes(name), Bytes(name+"2")); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ty := d.Type(Bytes("h")); len(ty) != 0 {
+		t.Fatalf("h left as %q", ty)
+	}
+	if val, err := d.Hget(Bytes("h2"), Bytes("f")); err != nil || string(val) != "v" {
+		t.Fatal(val, err)
+	}
+	if _, err := d.Hget(Bytes("h2"), Bytes("a")); err != ErrNotFound {
+		t.Fatal("deleted field copied", err)
+	}
+	if size, _ := d.Hsize(Bytes("h2")); size != 1 {
+		t.Fatal("hsize", size)
+	}
+	if score, err := d.Zget(Bytes("z2"), Bytes("b")); err != nil || score != 2 {
+		t.Fatal(score, err)
+	}
+	if size, _ := d.Zsize(Bytes("z2")); size != 2 {
+		t.Fatal("zsize", size)
+	}
+	if item, err := d.Qback(Bytes("q2")); err != nil || string(item) != "b" {
+		t.Fatal(item, err)
+	}
+	checkClean(t, d)
+}
+
+func TestDeleteAnyInTx(t *testing.T) {
+	d := openTestDB(t, Options{})
+	d.Hset(Bytes("x"), Bytes("a"), Bytes("1"))
+
+	err := d.Update(func(tx *Tx) error {
+		tx.Hset(Bytes("x"), Bytes("b"), Bytes("2"))
+		tx.Zset(Bytes("x"), Bytes("a"), 1)
+		tx.QpushFront(Bytes("x"), Bytes("a"))
+		tx.Set(Bytes("x"), Bytes("v"))
+		n, err := tx.DeleteAny(Bytes("x"))
+		if n != 4 {
+			t.Errorf("deleted %d types", n)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Exists(Bytes("x")) {
+		t.Fatalf("x left as %q", d.Type(Bytes("x")))
+	}
+	it := d.Iterator(nil, nil)
+	defer it.Close()
+	for it.Next() {
+		if it.Key()[0] != DTMETA {
+			t.Errorf("key left %q", it.Key())
+		}
+	}
+	checkClean(t, d)
+}
@@ -0,0 +1,223 @@
+package emssdb
+
+import (
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// The meta keys [DTMETA][name] hold the information of the db itself.
+// The format version is the version of the key layout the db is written with,
+// OpenDB migrates a db of an older version, and refuses a newer one.
+// A read only db of an older version is left as it is, and read from a migrated copy.
+
+const (
+	formatVersion = 4 // the format version written by this code
+)
+
+// MigrateProgress is called along a migration,
+// with the format version migrated from and the number of the keys done so far
+type MigrateProgress func(from int, done int64)
+
+// migration rewrite a key of a format version to the next version,
+// a nil nkey drops the key, an error stops the migration and leaves the db as it was
+type migration func(key, val Bytes) (nkey, nval Bytes, err error)
+
+// migrations[v] migrates the format version v to v+1
+var migrations = map[int]migration{}
+
+func registerMigration(from int, m migration) {
+	migrations[from] = m
+}
+
+// [DTMETA][name]
+func encodeMetaKey(name string) (ret Bytes) {
+	return encodeOneKey(DTMETA, Bytes(name))
+}
+
+// readFormat return the format version of db, 0 for an empty db
+func readFormat(db *leveldb.DB) (ret int, err error) {
+	val, err := db.Get(encodeMetaKey("format"), nil)
+	if err == nil {
+		return int(Bytes(val).GetUInt64()), nil
+	} else if err != leveldb.ErrNotFound {
+		return 0, err
+	}
+	it := db.NewIterator(nil, nil)
+	defer it.Release()
+	if it.First() {
+		// written before the format version was recorded
+		return 1, nil
+	}
+	return 0, it.Error()
+}
+
+func writeFormat(db *leveldb.DB, version int) (err error) {
+	return db.Put(encodeMetaKey("format"), NewByUInt64(uint64(version)), &opt.WriteOptions{Sync: true})
+}
+
+// openFormat open the leveldb at path, and migrate it to the current format version.
+// copyDir is the temporary directory of the migrated copy of a read only db of an older version.
+func openFormat(path string, o *opt.Options, progress MigrateProgress) (db *leveldb.DB, copyDir string, err error) {
+	// a migration stopped between the swap of the directories left the old db aside
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		if _, err := os.Stat(path + ".old"); err == nil {
+			if err = os.Rename(path+".old", path); err != nil {
+				return nil, "", err
+			}
+		}
+	}
+
+	if db, err = leveldb.OpenFile(path, o); err != nil {
+		return nil, "", err
+	}
+	version, err := readFormat(db)
+	if err == nil && version > formatVersion {
+		err = ErrFormatVersion
+	} else if err == nil && version == 0 && !o.ReadOnly {
+		err = writeFormat(db, formatVersion)
+	} else if err == nil && version > 0 && version < formatVersion {
+		db.Close()
+		if o.ReadOnly {
+			return openMigratedCopy(path, o, version, progress)
+		}
+		if err = migrate(path, o, version, progress); err != nil {
+			return nil, "", err
+		}
+		reopen := *o
+		reopen.ErrorIfExist = false
+		db, err = leveldb.OpenFile(path, &reopen)
+		return db, "", err
+	}
+	if err != nil {
+		db.Close()
+		return nil, "", err
+	}
+	return db, "", nil
+}
+
+// openMigratedCopy migrate the closed db at path from the format version into a copy
+// in a temporary directory, and open the copy read only. The db at path is not written.
+func openMigratedCopy(path string, o *opt.Options, version int, progress MigrateProgress) (db *leveldb.DB, copyDir string, err error) {
+	m, ok := migrations[version]
+	if !ok {
+		return nil, "", ErrFormatVersion
+	}
+	if copyDir, err = ioutil.TempDir("", "emssdb-readonly"); err != nil {
+		return nil, "", err
+	}
+	copyPath := filepath.Join(copyDir, "db")
+	wo := *o
+	wo.ReadOnly = false
+	wo.ErrorIfExist = false
+	if err = migrateCopy(path, copyPath, &wo, version, m, progress); err == nil {
+		err = migrate(copyPath, &wo, version+1, progress)
+	}
+	if err == nil {
+		ro := *o
+		ro.ErrorIfExist = false
+		db, err = leveldb.OpenFile(copyPath, &ro)
+	}
+	if err != nil {
+		os.RemoveAll(copyDir)
+		return nil, "", err
+	}
+	return db, copyDir, nil
+}
+
+// migrate migrate the closed db at path from the format version to the current one
+func migrate(path string, o *opt.Options, version int, progress MigrateProgress) (err error) {
+	for ; version < formatVersion; version++ {
+		m, ok := migrations[version]
+		if !ok {
+			return ErrFormatVersion
+		}
+		if err = migrateStep(path, o, version, m, progress); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateStep rewrite the db at path by m into a new directory, and then swap it in
+func migrateStep(path string, o *opt.Options, version int, m migration, progress MigrateProgress) (err error) {
+	tmp, old := path+".migrate", path+".old"
+	if err = os.RemoveAll(tmp); err != nil {
+		return err
+	}
+	if err = migrateCopy(path, tmp, o, version, m, progress); err != nil {
+		os.RemoveAll(tmp)
+		return err
+	}
+	if err = os.Rename(path, old); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp, path); err != nil {
+		return err
+	}
+	return os.RemoveAll(old)
+}
+
+func migrateCopy(path, tmp string, o *opt.Options, version int, m migration, progress MigrateProgress) (err error) {
+	ro := *o
+	ro.ErrorIfExist = false
+	ro.ReadOnly = true
+	src, err := leveldb.OpenFile(path, &ro)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	snap, err := src.GetSnapshot()
+	if err != nil {
+		return err
+	}
+	defer snap.Release()
+
+	no := *o
+	no.ErrorIfExist = true
+	ndb, err := leveldb.OpenFile(tmp, &no)
+	if err != nil {
+		return err
+	}
+	defer ndb.Close()
+
+	w := &bulkWriter{db: ndb}
+	it := snap.NewIterator(nil, &opt.ReadOptions{DontFillCache: true})
+	defer it.Release()
+	var done int64
+	for it.Next() {
+		key, val := Bytes(it.Key()), Bytes(it.Value())
+		if key[0] != DTMETA {
+			if key, val, err = m(key, val); err != nil {
+				return err
+			}
+		}
+		if key != nil {
+			if err = w.put(key, val); err != nil {
+				return err
+			}
+		}
+		if done++; progress != nil && done%loadBatch == 0 {
+			progress(version, done)
+		}
+	}
+	if err = it.Error(); err != nil {
+		return err
+	}
+	w.batch.Put(encodeMetaKey("format"), NewByUInt64(uint64(version+1)))
+	if err = w.flush(); err != nil {
+		return err
+	}
+	if progress != nil {
+		progress(version, done)
+	}
+	return nil
+}
+
+// FormatVersion return the format version of the key layout of the db
+func (d *DB) FormatVersion() (ret int) {
+	ret, _ = readFormat(d.db)
+	return ret
+}
@@ -0,0 +1,182 @@
+package emssdb
+
+import (
+	"fmt"
+	"github.com/syndtr/goleveldb/leveldb"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// the upper bounds in seconds of the buckets of the latency histograms
+var metricsBuckets = []float64{.00005, .0001, .00025, .0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5}
+
+// metrics is the metrics subsystem of a DB, enabled by Options.Metrics.
+// A nil *metrics is the disabled one, all its methods do nothing.
+type metrics struct {
+	mutex sync.RWMutex
+	cmds  map[string]*cmdMetrics
+
+	expired   int64 // keys deleted by the expire daemon
+	expireLag int64 // seconds the oldest due expiration waited at the last round
+}
+
+// cmdMetrics the metrics of a command, updated atomically
+type cmdMetrics struct {
+	count   int64
+	errors  int64
+	nanos   int64
+	buckets []int64 // not cumulative, the last one is +Inf
+}
+
+func newMetrics() *metrics {
+	return &metrics{cmds: make(map[string]*cmdMetrics)}
+}
+
+func (m *metrics) cmd(name string) (ret *cmdMetrics) {
+	m.mutex.RLock()
+	ret = m.cmds[name]
+	m.mutex.RUnlock()
+	if ret != nil {
+		return ret
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if ret = m.cmds[name]; ret == nil {
+		ret = &cmdMetrics{buckets: make([]int64, len(metricsBuckets)+1)}
+		m.cmds[name] = ret
+	}
+	return ret
+}
+
+// observe count a command started at start, it is deferred by the public methods.
+// errp points to the error result, nil for the methods without one.
+// ErrNotFound is a miss, not an error.
+func (m *metrics) observe(cmd string, start time.Time, errp *error) {
+	if m == nil {
+		return
+	}
+	c := m.cmd(cmd)
+	elapsed := time.Since(start)
+	atomic.AddInt64(&c.count, 1)
+	atomic.AddInt64(&c.nanos, int64(elapsed))
+	if errp != nil && *errp != nil && *errp != ErrNotFound {
+		atomic.AddInt64(&c.errors, 1)
+	}
+	i := sort.SearchFloat64s(metricsBuckets, elapsed.Seconds())
+	atomic.AddInt64(&c.buckets[i], 1)
+}
+
+// expireRound count a round of the expire daemon
+func (m *metrics) expireRound(expired, lag int64) {
+	if m == nil {
+		return
+	}
+	atomic.AddInt64(&m.expired, expired)
+	atomic.StoreInt64(&m.expireLag, lag)
+}
+
+func (m *metrics) write(w io.Writer) {
+	if m == nil {
+		return
+	}
+	m.mutex.RLock()
+	names := make([]string, 0, len(m.cmds))
+	for name := range m.cmds {
+		names = append(names, name)
+	}
+	m.mutex.RUnlock()
+	sort.Strings(names)
+
+	fmt.Fprintln(w, "# HELP emssdb_commands_total Calls of the DB methods.")
+	fmt.Fprintln(w, "# TYPE emssdb_commands_total counter")
+	for _, name := range names {
+		fmt.Fprintf(w, "emssdb_commands_total{cmd=%q} %d\n", name, atomic.LoadInt64(&m.cmd(name).count))
+	}
+	fmt.Fprintln(w, "# HELP emssdb_command_errors_total Calls of the DB methods which returned an error, but not found.")
+	fmt.Fprintln(w, "# TYPE emssdb_command_errors_total counter")
+	for _, name := range names {
+		fmt.Fprintf(w, "emssdb_command_errors_total{cmd=%q} %d\n", name, atomic.LoadInt64(&m.cmd(name).errors))
+	}
+	fmt.Fprintln(w, "# HELP emssdb_command_duration_seconds Latency of the DB methods.")
+	fmt.Fprintln(w, "# TYPE emssdb_command_duration_seconds histogram")
+	for _, name := range names {
+		c := m.cmd(name)
+		var cum int64
+		for i, le := range metricsBuckets {
+			cum += atomic.LoadInt64(&c.buckets[i])
+			fmt.Fprintf(w, "emssdb_command_duration_seconds_bucket{cmd=%q,le=%q} %d\n", name, strconv.FormatFloat(le, 'g', -1, 64), cum)
+		}
+		cum += atomic.LoadInt64(&c.buckets[len(metricsBuckets)])
+		fmt.Fprintf(w, "emssdb_command_duration_seconds_bucket{cmd=%q,le=\"+Inf\"} %d\n", name, cum)
+		fmt.Fprintf(w, "emssdb_command_duration_seconds_sum{cmd=%q} %g\n", name, time.Duration(atomic.LoadInt64(&c.nanos)).Seconds())
+		fmt.Fprintf(w, "emssdb_command_duration_seconds_count{cmd=%q} %d\n", name, cum)
+	}
+
+	fmt.Fprintln(w, "# HELP emssdb_expired_keys_total Keys deleted by the expire daemon.")
+	fmt.Fprintln(w, "# TYPE emssdb_expired_keys_total counter")
+	fmt.Fprintf(w, "emssdb_expired_keys_total %d\n", atomic.LoadInt64(&m.expired))
+	fmt.Fprintln(w, "# HELP emssdb_expire_lag_seconds How long the oldest due expiration waited at the last round of the expire daemon.")
+	fmt.Fprintln(w, "# TYPE emssdb_expire_lag_seconds gauge")
+	fmt.Fprintf(w, "emssdb_expire_lag_seconds %d\n", atomic.LoadInt64(&m.expireLag))
+}
+
+// writeLeveldbMetrics write the compaction, write stall and io stats of leveldb
+func writeLeveldbMetrics(w io.Writer, ls *leveldb.DBStats) {
+	metric := func(name, typ, help string) {
+		fmt.Fprintf(w, "# HELP emssdb_leveldb_%s %s\n# TYPE emssdb_leveldb_%s %s\n", name, help, name, typ)
+	}
+	levels := func(name, typ, help string, val func(level int) string) {
+		metric(name, typ, help)
+		for level := range ls.LevelSizes {
+			fmt.Fprintf(w, "emssdb_leveldb_%s{level=\"%d\"} %s\n", name, level, val(level))
+		}
+	}
+	levels("level_tables", "gauge", "Tables of each level.", func(l int) string { return strconv.Itoa(ls.LevelTablesCounts[l]) })
+	levels("level_bytes", "gauge", "Bytes of the tables of each level.", func(l int) string { return strconv.FormatInt(ls.LevelSizes[l], 10) })
+	levels("compaction_read_bytes_total", "counter", "Bytes read by the compactions into each level.", func(l int) string { return strconv.FormatInt(ls.LevelRead[l], 10) })
+	levels("compaction_write_bytes_total", "counter", "Bytes written by the compactions into each level.", func(l int) string { return strconv.FormatInt(ls.LevelWrite[l], 10) })
+	levels("compaction_seconds_total", "counter", "Time spent by the compactions into each level.", func(l int) string { return fmt.Sprint(ls.LevelDurations[l].Seconds()) })
+
+	metric("write_delays_total", "counter", "Writes delayed by the compactions.")
+	fmt.Fprintf(w, "emssdb_leveldb_write_delays_total %d\n", ls.WriteDelayCount)
+	metric("write_delay_seconds_total", "counter", "Time the writes were delayed by the compactions.")
+	fmt.Fprintf(w, "emssdb_leveldb_write_delay_seconds_total %g\n", ls.WriteDelayDuration.Seconds())
+	paused := 0
+	if ls.WritePaused {
+		paused = 1
+	}
+	metric("write_paused", "gauge", "1 if the writes are paused by the compactions.")
+	fmt.Fprintf(w, "emssdb_leveldb_write_paused %d\n", paused)
+	metric("io_read_bytes_total", "counter", "Bytes read from the storage.")
+	fmt.Fprintf(w, "emssdb_leveldb_io_read_bytes_total %d\n", ls.IORead)
+	metric("io_write_bytes_total", "counter", "Bytes written to the storage.")
+	fmt.Fprintf(w, "emssdb_leveldb_io_write_bytes_total %d\n", ls.IOWrite)
+	metric("alive_snapshots", "gauge", "Snapshots not released.")
+	fmt.Fprintf(w, "emssdb_leveldb_alive_snapshots %d\n", ls.AliveSnapshots)
+	metric("alive_iterators", "gauge", "Iterators not released.")
+	fmt.Fprintf(w, "emssdb_leveldb_alive_iterators %d\n", ls.AliveIterators)
+	metric("open_tables", "gauge", "Tables in the open files cache.")
+	fmt.Fprintf(w, "emssdb_leveldb_open_tables %d\n", ls.OpenedTablesCount)
+	metric("block_cache_bytes", "gauge", "Bytes in the block cache.")
+	fmt.Fprintf(w, "emssdb_leveldb_block_cache_bytes %d\n", ls.BlockCacheSize)
+}
+
+// MetricsHandler return a http.Handler serving the metrics in the prometheus text format.
+// Without Options.Metrics it serves the leveldb stats only.
+func (d *DB) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var ls leveldb.DBStats
+		if err := d.db.Stats(&ls); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		d.metrics.write(w)
+		writeLeveldbMetrics(w, &ls)
+	})
+}
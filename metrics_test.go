@@ -0,0 +1,24 @@
+package emssdb
+
+import "testing"
+
+func TestMetricsCountOnce(t *testing.T) {
+	d := openTestDB(t, Options{Metrics: true})
+	keys := []Bytes{Bytes("a"), Bytes("b")}
+	if err := d.MultiSet(keys, keys); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.MultiDelete(keys); err != nil {
+		t.Fatal(err)
+	}
+	d.RawSet(Bytes("r"), Bytes("v"))
+	for cmd, count := range map[string]int64{"multiset": 1, "multidelete": 1, "rawset": 1, "update": 0} {
+		var got int64
+		if c := d.metrics.cmds[cmd]; c != nil {
+			got = c.count
+		}
+		if got != count {
+			t.Errorf("%s counted %d times, want %d", cmd, got, count)
+		}
+	}
+}
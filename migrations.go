@@ -0,0 +1,119 @@
+package emssdb
+
+import "encoding/binary"
+
+// The migrations between the format versions. A migration reads and writes
+// the layouts of its own versions by hand, not by the encoders of the data
+// types, which follow the current layout.
+
+func init() {
+	registerMigration(1, migrateQueueNames)
+	registerMigration(2, migrateNameVarint)
+	registerMigration(3, migrateDropVersions)
+}
+
+// splitNamespaceV1 split a key of the layouts 1 and 2 to the prefix of its
+// namespace, [DTNAMESPACE][len(name)][name][0], and the key in the namespace.
+// The prefix is nil for a key of no namespace.
+func splitNamespaceV1(key Bytes) (prefix, nkey Bytes) {
+	if len(key) < 3 || key[0] != DTNAMESPACE || int(key[1])+3 > len(key) {
+		return nil, key
+	}
+	n := int(key[1]) + 3
+	return key[:n], key[n:]
+}
+
+// migrateQueueNames 1 => 2, prefix the names of the queue items by their length:
+// [DTQUEUE][name][0][seq] => [DTQUEUE][len(name)][name][0][seq]
+// The layout 1 had no limit on the queue names, the names over 255 bytes are
+// written with the uvarint length of the layout 3 instead, see isQueueKeyV2.
+func migrateQueueNames(key, val Bytes) (nkey, nval Bytes, err error) {
+	prefix, qkey := splitNamespaceV1(key)
+	if len(qkey) < 10 || qkey[0] != DTQUEUE {
+		return key, val, nil
+	}
+	name := qkey[1 : len(qkey)-9]
+	var blen [binary.MaxVarintLen64]byte
+	n := 1
+	blen[0] = byte(len(name))
+	if len(name) > SSDB_KEY_LEN_MAX {
+		n = binary.PutUvarint(blen[:], uint64(len(name)))
+	}
+	nkey = make(Bytes, 0, len(key)+n)
+	nkey = append(nkey, prefix...)
+	nkey = append(nkey, DTQUEUE)
+	nkey = append(nkey, blen[:n]...)
+	nkey = append(nkey, qkey[1:]...)
+	return nkey, val, nil
+}
+
+// isQueueKeyV2 tell whether a queue item key is of the layout 2,
+// [DTQUEUE][len(name)][name][0][seq], and not one of a long name already written
+// with a uvarint length by migrateQueueNames: those are at least 268 bytes long,
+// 2 bytes of length and 256 of name, above any key of a length byte.
+func isQueueKeyV2(qkey Bytes) (ret bool) {
+	return len(qkey) >= 2 && len(qkey) == int(qkey[1])+11
+}
+
+// migrateNameVarint 2 => 3, write the lengths of the names as uvarints:
+// [DT][len(name)][name][0][...] => [DT][uvarint len(name)][name][0][...]
+// for the hashes, the zsets and their scores, the queues and the namespaces.
+// The lengths below 128 are the same byte.
+func migrateNameVarint(key, val Bytes) (nkey, nval Bytes, err error) {
+	prefix, dkey := splitNamespaceV1(key)
+	if prefix != nil {
+		prefix = varintNameV2(prefix)
+	}
+	if len(dkey) > 0 {
+		switch dkey[0] {
+		case DTHASH, DTZSET, DTZSCORE:
+			dkey = varintNameV2(dkey)
+		case DTQUEUE:
+			if isQueueKeyV2(dkey) {
+				dkey = varintNameV2(dkey)
+			}
+		}
+	}
+	nkey = make(Bytes, 0, len(prefix)+len(dkey))
+	nkey = append(nkey, prefix...)
+	nkey = append(nkey, dkey...)
+	return nkey, val, nil
+}
+
+// varintNameV2 rewrite the length byte of [DT][len(name)][name][0][...] as a uvarint
+func varintNameV2(key Bytes) (ret Bytes) {
+	if len(key) < 2 || key[1] < 0x80 {
+		return key
+	}
+	var blen [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(blen[:], uint64(key[1]))
+	ret = make(Bytes, 0, len(key)+n-1)
+	ret = append(ret, key[0])
+	ret = append(ret, blen[:n]...)
+	return append(ret, key[2:]...)
+}
+
+// migrateDropVersions 3 => 4, drop the version stamps: they were counters of the
+// writes of each container, never deleted with it, and the epochs of the layout 4
+// start from 0. The stamps of the containers are written again by their next writes.
+func migrateDropVersions(key, val Bytes) (nkey, nval Bytes, err error) {
+	if _, dkey := splitNamespaceV3(key); len(dkey) > 0 && dkey[0] == DTVERSION {
+		return nil, nil, nil
+	}
+	return key, val, nil
+}
+
+// splitNamespaceV3 split a key of the layout 3 to the prefix of its namespace,
+// [DTNAMESPACE][uvarint len(name)][name][0], and the key in the namespace.
+// The prefix is nil for a key of no namespace.
+func splitNamespaceV3(key Bytes) (prefix, nkey Bytes) {
+	if len(key) < 3 || key[0] != DTNAMESPACE {
+		return nil, key
+	}
+	length, n := binary.Uvarint(key[1:])
+	if n <= 0 || length >= uint64(len(key)) || 1+n+int(length)+1 > len(key) {
+		return nil, key
+	}
+	end := 1 + n + int(length) + 1
+	return key[:end], key[end:]
+}
@@ -0,0 +1,222 @@
+package emssdb
+
+import (
+	"bytes"
+	"github.com/syndtr/goleveldb/leveldb"
+	"os"
+	"strings"
+	"testing"
+)
+
+// twoKeyV1 a key of the layouts 1 and 2, [dt][len(name)][name][0][rest]
+func twoKeyV1(dt byte, name, rest string) (ret string) {
+	return string([]byte{dt, byte(len(name))}) + name + "\x00" + rest
+}
+
+// namespaceV1 the prefix of the namespace name in the layouts 1 and 2, empty for the db
+func namespaceV1(name string) (ret string) {
+	if name == "" {
+		return ""
+	}
+	return twoKeyV1(DTNAMESPACE, name, "")
+}
+
+// queueKeysOf write the keys of a queue in the layout version, items from the front to the back:
+// [DTQUEUE][name][0][seq] in the layout 1, [DTQUEUE][len(name)][name][0][seq] in the layout 2.
+// The names over 255 bytes are in the layout 3 in a db of the layout 2, as migrateQueueNames writes them.
+func queueKeysOf(version int, keys map[string]Bytes, prefix, name string, items ...string) {
+	item := func(seq int64) string {
+		switch {
+		case version == 1:
+			return prefix + string(DTQUEUE) + name + "\x00" + string(NewByInt64(seq))
+		case len(name) <= SSDB_KEY_LEN_MAX:
+			return prefix + twoKeyV1(DTQUEUE, name, string(NewByInt64(seq)))
+		}
+		return prefix + string(encodeQitemKey(Bytes(name), seq))
+	}
+	front := int64(qITEM_SEQ_INIT)
+	for i, val := range items {
+		keys[item(front-int64(i))] = Bytes(val)
+	}
+	keys[item(qFRONT_SEQ)] = NewByInt64(front)
+	keys[item(qBACK_SEQ)] = NewByInt64(front - int64(len(items)) + 1)
+	keys[prefix+string(DTQSIZE)+name] = NewByInt64(int64(len(items)))
+}
+
+var (
+	migrateNamespaces = []string{"", "ns\x00x", strings.Repeat("n", 128)}
+	migrateNames      = []string{strings.Repeat("a", 127), strings.Repeat("b", 128), strings.Repeat("c", 255), "a\x00b"}
+)
+
+// legacyKeyspace build the keys of a db of the layout version with a hash, a zset and a queue
+// of every name in every namespace, and the keys of the same data in the current layout
+func legacyKeyspace(version int, names []string) (keys, want map[string]Bytes) {
+	keys, want = map[string]Bytes{}, map[string]Bytes{}
+	score := string(NewByUInt64(enInt(-5)))
+	for _, ns := range migrateNamespaces {
+		prefix := namespaceV1(ns)
+		raw := func(key Bytes) string {
+			if ns == "" {
+				return string(key)
+			}
+			return string(encodeNamespacePrefix(Bytes(ns))) + string(key)
+		}
+		for _, name := range names {
+			bname := Bytes(name)
+			if len(name) <= SSDB_KEY_LEN_MAX {
+				keys[prefix+twoKeyV1(DTHASH, name, "f\x00g")] = Bytes("v")
+				keys[prefix+string(DTHSIZE)+name] = NewByInt64(1)
+				keys[prefix+twoKeyV1(DTZSET, name, "m\x00")] = NewByInt64(-5)
+				keys[prefix+twoKeyV1(DTZSCORE, name, score+"m\x00")] = Bytes{}
+				keys[prefix+string(DTZSIZE)+name] = NewByInt64(1)
+				keys[prefix+string([]byte{DTVERSION, DTZSET})+name] = NewByUInt64(3)
+
+				want[raw(encodeHashKey(bname, Bytes("f\x00g")))] = Bytes("v")
+				want[raw(encodeHsizeKey(bname))] = NewByInt64(1)
+				want[raw(encodeZsetKey(bname, Bytes("m\x00")))] = NewByInt64(-5)
+				want[raw(encodeZscoreKey(bname, Bytes("m\x00"), -5))] = Bytes{}
+				want[raw(encodeZsizeKey(bname))] = NewByInt64(1)
+			}
+			queueKeysOf(version, keys, prefix, name, "x", "y")
+			front := int64(qITEM_SEQ_INIT)
+			want[raw(encodeQitemKey(bname, front))] = Bytes("x")
+			want[raw(encodeQitemKey(bname, front-1))] = Bytes("y")
+			want[raw(encodeQitemKey(bname, qFRONT_SEQ))] = NewByInt64(front)
+			want[raw(encodeQitemKey(bname, qBACK_SEQ))] = NewByInt64(front - 1)
+			want[raw(encodeQsizeKey(bname))] = NewByInt64(2)
+		}
+	}
+	keys[string(encodeKvKey(Bytes("k\x00")))] = Bytes("v")
+	keys[string(encodeVersionKey(DTKV, Bytes("k\x00")))] = NewByUInt64(1)
+	want[string(encodeKvKey(Bytes("k\x00")))] = Bytes("v")
+	keys[string(encodeMetaKey("format"))] = NewByUInt64(uint64(version))
+	return keys, want
+}
+
+func testMigrate(t *testing.T, version int, names []string) {
+	path := t.TempDir()
+	keys, want := legacyKeyspace(version, names)
+	writeRawDB(t, path, keys)
+	d := openTestDB(t, Options{Path: path})
+	if d.FormatVersion() != formatVersion {
+		t.Fatal("format", d.FormatVersion())
+	}
+	checkMigrated(t, d, names, want)
+}
+
+// checkMigrated check that d holds the keys of legacyKeyspace in the current layout
+func checkMigrated(t *testing.T, d *DB, names []string, want map[string]Bytes) {
+	t.Helper()
+	it := d.db.NewIterator(nil, nil)
+	defer it.Release()
+	got := 0
+	for it.Next() {
+		if it.Key()[0] == DTMETA {
+			continue
+		}
+		got++
+		if val, ok := want[string(it.Key())]; !ok {
+			t.Errorf("unexpected key %q", it.Key())
+		} else if !bytes.Equal(val, it.Value()) {
+			t.Errorf("key %q: value %q, want %q", it.Key(), it.Value(), val)
+		}
+	}
+	if got != len(want) {
+		t.Fatalf("%d keys, want %d", got, len(want))
+	}
+
+	for _, ns := range migrateNamespaces {
+		h := d
+		if ns != "" {
+			h, _ = d.Namespace(ns)
+		}
+		for _, name := range names {
+			bname := Bytes(name)
+			if len(name) <= SSDB_KEY_LEN_MAX {
+				if val, err := h.Hget(bname, Bytes("f\x00g")); err != nil || string(val) != "v" {
+					t.Errorf("hget %q %q: %q %v", ns, name, val, err)
+				}
+				zit := h.Zscan(bname, -10, 0)
+				if !zit.Next() || string(zit.Key()) != "m\x00" || zit.Score() != -5 {
+					t.Errorf("zscan %q %q: %q %d", ns, name, zit.Key(), zit.Score())
+				}
+				zit.Close()
+			}
+			if item, err := h.Qfront(bname); err != nil || string(item) != "x" {
+				t.Errorf("qfront %q %q: %q %v", ns, name, item, err)
+			}
+			if item, err := h.Qback(bname); err != nil || string(item) != "y" {
+				t.Errorf("qback %q %q: %q %v", ns, name, item, err)
+			}
+		}
+	}
+	checkClean(t, d)
+}
+
+func TestMigrateV1(t *testing.T) {
+	// the layout 1 had no limit on the queue names
+	testMigrate(t, 1, append(migrateNames, strings.Repeat("q", 300)))
+}
+
+func TestMigrateV2(t *testing.T) {
+	// a migration from 1 stopped before 3 leaves the long queue names in the layout 3
+	testMigrate(t, 2, append(migrateNames, strings.Repeat("q", 300)))
+}
+
+func TestMigrateKeys(t *testing.T) {
+	long := strings.Repeat("q", 300)
+	seq := string(NewByInt64(qFRONT_SEQ))
+	for _, c := range []struct {
+		m         migration
+		key, want string
+	}{
+		{migrateQueueNames, string(DTQUEUE) + "a\x00b\x00" + seq, twoKeyV1(DTQUEUE, "a\x00b", seq)},
+		{migrateQueueNames, string(DTQUEUE) + long + "\x00" + seq, string(encodeQitemKey(Bytes(long), qFRONT_SEQ))},
+		{migrateQueueNames, namespaceV1("ns") + string(DTQUEUE) + "q\x00" + seq, namespaceV1("ns") + twoKeyV1(DTQUEUE, "q", seq)},
+		{migrateQueueNames, twoKeyV1(DTHASH, "h", "f"), twoKeyV1(DTHASH, "h", "f")},
+		{migrateNameVarint, twoKeyV1(DTZSCORE, strings.Repeat("z", 128), "s"), string(encodeTwoKey(DTZSCORE, Bytes(strings.Repeat("z", 128)), 0, Bytes("s")))},
+		{migrateNameVarint, twoKeyV1(DTQUEUE, strings.Repeat("q", 255), seq), string(encodeQitemKey(Bytes(strings.Repeat("q", 255)), qFRONT_SEQ))},
+		{migrateNameVarint, string(encodeQitemKey(Bytes(long), qBACK_SEQ)), string(encodeQitemKey(Bytes(long), qBACK_SEQ))},
+		{migrateDropVersions, string(encodeVersionKey(DTHASH, Bytes("h"))), ""},
+		{migrateDropVersions, string(encodeNamespacePrefix(Bytes(long))) + string(encodeVersionKey(DTQUEUE, Bytes("q"))), ""},
+		{migrateDropVersions, string(encodeNamespacePrefix(Bytes(long))) + string(encodeHashKey(Bytes("v"), Bytes("f"))), string(encodeNamespacePrefix(Bytes(long))) + string(encodeHashKey(Bytes("v"), Bytes("f")))},
+		{migrateNameVarint, namespaceV1(strings.Repeat("n", 200)) + twoKeyV1(DTHASH, "h", "f"), string(encodeNamespacePrefix(Bytes(strings.Repeat("n", 200)))) + string(encodeHashKey(Bytes("h"), Bytes("f")))},
+	} {
+		nkey, _, err := c.m(Bytes(c.key), nil)
+		if err != nil || string(nkey) != c.want {
+			t.Errorf("%q => %q %v, want %q", c.key, nkey, err, c.want)
+		}
+	}
+}
+
+func TestReadOnlyLegacy(t *testing.T) {
+	path := t.TempDir()
+	names := append(migrateNames, strings.Repeat("q", 300))
+	keys, want := legacyKeyspace(1, names)
+	delete(keys, string(encodeMetaKey("format")))
+	writeRawDB(t, path, keys)
+
+	d, err := OpenDB(Options{Path: path, ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.FormatVersion() != formatVersion {
+		t.Fatal("format", d.FormatVersion())
+	}
+	checkMigrated(t, d, names, want)
+	copyDir := d.copyDir
+	d.Close()
+	if _, err := os.Stat(copyDir); !os.IsNotExist(err) {
+		t.Fatal("copy left", err)
+	}
+
+	// the data directory is left in the layout 1
+	ldb, err := leveldb.OpenFile(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ldb.Close()
+	if version, err := readFormat(ldb); err != nil || version != 1 {
+		t.Fatal(version, err)
+	}
+}
@@ -0,0 +1,229 @@
+package emssdb
+
+import (
+	"bytes"
+	"github.com/syndtr/goleveldb/leveldb/iterator"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// A namespace is a logical db inside the DB, its keys are the keys of the
+// data types prefixed by [DTNAMESPACE][uvarint len(name)][name][0].
+// The handle of a namespace is a *DB sharing the leveldb, the writer and the
+// expire daemon of its DB, the prefix is added by its reader and its Tx.
+// The data methods, Dump, Load, ImportSSDB, Check, Repair, Compact and Info of
+// the handle cover the namespace only; Backup, Stats and the metrics cover the whole db.
+
+// [DTNAMESPACE][uvarint len(name)][name][0]
+func encodeNamespacePrefix(name Bytes) (ret Bytes) {
+	return encodeTwoKey(DTNAMESPACE, name, 0, nil)
+}
+
+// the limit of the keys of the namespace name
+func encodeNamespaceLimit(name Bytes) (ret Bytes) {
+	return encodeTwoKey(DTNAMESPACE, name, 1, nil)
+}
+
+// decodeNamespaceKey split a raw key of a namespace to the name and the key in the namespace
+func decodeNamespaceKey(slice Bytes) (name, key Bytes) {
+	return decodeTwoKey(slice)
+}
+
+// nsReader is the reader of a namespace, it prefixes the keys it reads
+// and strips the prefix from the keys of its iterators
+type nsReader struct {
+	reader
+	prefix Bytes
+	limit  Bytes
+}
+
+func (r *nsReader) key(key []byte) (ret Bytes) {
+	ret = make(Bytes, len(r.prefix)+len(key))
+	copy(ret, r.prefix)
+	copy(ret[len(r.prefix):], key)
+	return ret
+}
+
+func (r *nsReader) Get(key []byte, ro *opt.ReadOptions) (value []byte, err error) {
+	return r.reader.Get(r.key(key), ro)
+}
+
+func (r *nsReader) NewIterator(slice *util.Range, ro *opt.ReadOptions) iterator.Iterator {
+	rng := util.Range{Start: r.prefix, Limit: r.limit}
+	if slice != nil && slice.Start != nil {
+		rng.Start = r.key(slice.Start)
+	}
+	if slice != nil && slice.Limit != nil {
+		rng.Limit = r.key(slice.Limit)
+	}
+	return &nsIterator{Iterator: r.reader.NewIterator(&rng, ro), r: r}
+}
+
+type nsIterator struct {
+	iterator.Iterator
+	r *nsReader
+}
+
+func (it *nsIterator) Key() []byte {
+	key := it.Iterator.Key()
+	if key == nil {
+		return nil
+	}
+	return key[len(it.r.prefix):]
+}
+
+func (it *nsIterator) Seek(key []byte) bool {
+	return it.Iterator.Seek(it.r.key(key))
+}
+
+// base return the DB of a namespace, or d itself
+func (d *DB) base() (ret *DB) {
+	if d.root != nil {
+		return d.root
+	}
+	return d
+}
+
+// Namespace return the handle of the namespace name, the namespaces are not nested:
+// the Namespace of a handle is a namespace of its DB.
+// The handle needs no Close, it is closed with its DB.
+func (d *DB) Namespace(name string) (ret *DB, err error) {
+	if len(name) == 0 {
+		return nil, ErrEmptyKey
+	}
+	root := d.base()
+	if len(name) > root.maxKeyLen {
+		return nil, ErrLongKey
+	}
+	ns := &DB{
+		db:          root.db,
+		options:     root.options,
+		writer:      root.writer,
+		expireDelay: root.expireDelay,
+		readOnly:    root.readOnly,
+		counters:    root.counters,
+		epochs:      root.epochs,
+		quit:        root.quit,
+		root:        root,
+		namespace:   name,
+		prefix:      encodeNamespacePrefix(Bytes(name)),
+	}
+	ns.metrics = root.metrics
+	ns.maxKeyLen = root.maxKeyLen
+	ns.r = ns.prefixed(root.r)
+	return ns, nil
+}
+
+// NamespaceName return the name of the namespace of the handle, empty for a DB
+func (d *DB) NamespaceName() (ret string) {
+	return d.namespace
+}
+
+// prefixed return r itself for a DB, or the reader of the namespace over r
+func (d *DB) prefixed(r reader) (ret reader) {
+	if d.root == nil {
+		return r
+	}
+	return &nsReader{reader: r, prefix: d.prefix, limit: encodeNamespaceLimit(Bytes(d.namespace))}
+}
+
+// rawKey return the key in leveldb of a key of d
+func (d *DB) rawKey(key Bytes) (ret Bytes) {
+	if d.root == nil {
+		return key
+	}
+	ret = make(Bytes, len(d.prefix)+len(key))
+	copy(ret, d.prefix)
+	copy(ret[len(d.prefix):], key)
+	return ret
+}
+
+// rawRange return the range in leveldb of a range of d, the nil bounds are the bounds of d
+func (d *DB) rawRange(r util.Range) (ret util.Range) {
+	if d.root == nil {
+		return r
+	}
+	ret = util.Range{Start: d.prefix, Limit: encodeNamespaceLimit(Bytes(d.namespace))}
+	if r.Start != nil {
+		ret.Start = d.rawKey(r.Start)
+	}
+	if r.Limit != nil {
+		ret.Limit = d.rawKey(r.Limit)
+	}
+	return ret
+}
+
+// Namespaces return the sorted names of the namespaces which have keys
+func (d *DB) Namespaces() (ret []string) {
+	ret = namespaceNames(d.db)
+	sort.Strings(ret)
+	return ret
+}
+
+// namespaceNames list the namespaces in r, seeking over the keys of each one
+func namespaceNames(r reader) (ret []string) {
+	it := r.NewIterator(&util.Range{Start: []byte{DTNAMESPACE}, Limit: []byte{DTNAMESPACE + 1}}, &opt.ReadOptions{DontFillCache: true})
+	defer it.Release()
+	for ok := it.First(); ok; {
+		name, _ := decodeNamespaceKey(it.Key())
+		if name == nil {
+			// undecodable, step over it
+			ok = it.Next()
+			continue
+		}
+		ret = append(ret, string(name))
+		if limit := encodeNamespaceLimit(name); bytes.Compare(limit, it.Key()) > 0 {
+			ok = it.Seek(limit)
+		} else {
+			// not the canonical prefix of name, a seek would go back
+			ok = it.Next()
+		}
+	}
+	return ret
+}
+
+// DropNamespace delete all the keys of the namespace name, and return the number of them.
+// It deletes in batches, so the namespace should not be written meanwhile.
+func (d *DB) DropNamespace(name string) (ret int64, err error) {
+	defer d.metrics.observe("dropnamespace", time.Now(), &err)
+	ns, err := d.Namespace(name)
+	if err != nil {
+		return 0, err
+	}
+	rng := ns.rawRange(util.Range{})
+	root := d.base()
+	for {
+		var n int64
+		err = root.updateAll(func(tx *Tx) error {
+			it := root.db.NewIterator(&rng, &opt.ReadOptions{DontFillCache: true})
+			defer it.Release()
+			for n < loadBatch && it.Next() {
+				tx.delete(NewByClone(it.Key()))
+				n++
+			}
+			return it.Error()
+		})
+		if err != nil {
+			return ret, err
+		}
+		if ret += n; n < loadBatch {
+			return ret, nil
+		}
+	}
+}
+
+// namespaceInfo the Info of a namespace, the number of its keys of each data type and its size
+func (d *DB) namespaceInfo() (ret map[string]string) {
+	ret = map[string]string{"namespace": d.namespace}
+	for name, dt := range map[string]byte{"kv": DTKV, "exkv": DTEXKV, "hash": DTHSIZE, "zset": DTZSIZE, "queue": DTQSIZE} {
+		rng := d.rawRange(util.Range{Start: encodeOneKey(dt, nil), Limit: encodeOneKey(dt+1, nil)})
+		ret["namespace."+name] = strconv.FormatInt(d.countKeys(rng.Start, rng.Limit), 10)
+	}
+	if sizes, err := d.db.SizeOf([]util.Range{d.rawRange(util.Range{})}); err == nil {
+		ret["namespace.size"] = strconv.FormatInt(sizes.Sum(), 10)
+	}
+	return ret
+}
@@ -4,9 +4,49 @@ import (
 	"time"
 )
 
+// SyncPolicy when to fsync the commits
+type SyncPolicy int
+
+const (
+	SyncNone       SyncPolicy = iota // leave the flush to the OS
+	SyncEveryWrite                   // fsync every commit
+	SyncByInterval                   // fsync the first commit of every SyncInterval, and the ones left unsynced at its end
+)
+
 type Options struct {
 	Path        string
-	CacheSize   int
+	CacheSize   int // MB, 8 by default
 	Compression bool
 	ExpireDelay time.Duration
+
+	WriteBufferSize     int // MB, 4 by default
+	BlockSize           int // KB, 4 by default
+	BloomBits           int // bits per key of the bloom filter, 10 by default, negative to disable
+	OpenFilesCache      int // capacity of the open files cache, 500 by default
+	CompactionTableSize int // MB, 2 by default
+
+	Sync         SyncPolicy
+	SyncInterval time.Duration // for SyncByInterval, 1s by default
+	// GroupCommit coalesce the commits of concurrent writers into one write.
+	// It pays off with Sync on disks of slow fsync, see test/bench
+	GroupCommit bool
+
+	// ReadOnly open the db read only, the writes return ErrReadOnly
+	// and the expired keys are not deleted.
+	// A db of an older format version is not migrated, it is read from a migrated
+	// copy taken at the open in a temporary directory, removed by Close.
+	ReadOnly     bool
+	ErrorIfExist bool
+
+	// MaxKeyLen the max bytes of the names of the hashes, zsets, queues and namespaces,
+	// and of the keys of the hashes and zsets, 4096 by default.
+	// It is checked by the writes creating them, the longer ones written before are still read and deleted.
+	MaxKeyLen int
+
+	// MigrateProgress is called while OpenDB migrates a db of an older format version
+	MigrateProgress MigrateProgress
+
+	// Metrics measure the calls of the DB methods and the expire daemon,
+	// served by MetricsHandler with the stats of leveldb
+	Metrics bool
 }
@@ -0,0 +1,196 @@
+package emssdb
+
+import (
+	"bytes"
+	"encoding/base64"
+	"time"
+)
+
+// The page scans read a page of a scan without keeping an iterator open.
+// The cursor of a page is the base64 of [direction][last key], the key is the
+// key of the view (without the namespace prefix), so a cursor resumes the scan
+// right after the last item even if the items around it were changed meanwhile.
+// It is opaque for the callers and valid for the same scan of the same handle only.
+
+// PageItem an item of a page, the fields of its data type are set:
+// Key and Value of kv and hash, Key, Value and Etime of exkv,
+// Key (the member) and Score of zset, Seq and Value of queue
+type PageItem struct {
+	Key   Bytes
+	Value Bytes
+	Etime uint64
+	Score int64
+	Seq   int64
+}
+
+func encodeCursor(direction int, key Bytes) (ret string) {
+	buf := make(Bytes, 1+len(key))
+	buf[0] = byte(direction)
+	copy(buf[1:], key)
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+func decodeCursor(cursor string) (direction int, key Bytes, err error) {
+	buf, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil || len(buf) < 2 || (buf[0] != FORWARD && buf[0] != BACKWARD) {
+		return 0, nil, ErrBadCursor
+	}
+	return int(buf[0]), buf[1:], nil
+}
+
+// scanPage read at most limit items of [start, end) in direction, after the key of cursor,
+// item decodes the key and the value of leveldb.
+// The cursor returned is empty at the end of the scan.
+func (v *view) scanPage(start, end Bytes, direction int, cursor string, limit int, item func(key, val Bytes) PageItem) (ret []PageItem, next string, err error) {
+	if cursor != "" {
+		dir, last, cerr := decodeCursor(cursor)
+		if cerr != nil {
+			return nil, "", cerr
+		}
+		if dir != direction || bytes.Compare(last, start) < 0 || (len(end) > 0 && bytes.Compare(last, end) >= 0) {
+			return nil, "", ErrBadCursor
+		}
+		if direction == FORWARD {
+			// the first key after last
+			start = append(last, 0)
+		} else {
+			end = last
+		}
+	}
+	if limit <= 0 {
+		return nil, cursor, nil
+	}
+	var it *Iterator
+	if direction == FORWARD {
+		it = v.Iterator(start, end)
+	} else {
+		it = v.RevIterator(start, end)
+	}
+	defer it.Close()
+	for len(ret) < limit && it.next() {
+		ret = append(ret, item(NewByClone(it.it.Key()), NewByClone(it.it.Value())))
+	}
+	if len(ret) == limit {
+		last := NewByClone(it.it.Key())
+		if it.next() {
+			next = encodeCursor(direction, last)
+		}
+	}
+	return ret, next, it.it.Error()
+}
+
+func kvPageItem(key, val Bytes) (ret PageItem) {
+	return PageItem{Key: key[1:], Value: val}
+}
+
+func exkvPageItem(key, val Bytes) (ret PageItem) {
+	val, etime := decodeExkvValue(val)
+	return PageItem{Key: key[1:], Value: val, Etime: etime}
+}
+
+func hashPageItem(key, val Bytes) (ret PageItem) {
+	_, field := decodeHashKey(key)
+	return PageItem{Key: field, Value: val}
+}
+
+func zsetPageItem(key, val Bytes) (ret PageItem) {
+	_, member, score := decodeZscoreKey(key)
+	return PageItem{Key: member, Score: score}
+}
+
+func queuePageItem(key, val Bytes) (ret PageItem) {
+	_, seq := decodeQitemKey(key)
+	return PageItem{Seq: seq, Value: val}
+}
+
+func kvPageRange(start, end Bytes) (keyStart, keyEnd Bytes) {
+	keyStart, keyEnd = encodeKvKey(start), encodeKvKey(end)
+	if len(end) == 0 {
+		keyEnd = encodeOneKey(DTKV+1, end)
+	}
+	return keyStart, keyEnd
+}
+
+// ScanPage read a page of at most limit kv of [start, end) like Scan,
+// starting after cursor, or at start for an empty cursor.
+// It returns the cursor of the next page, empty at the end of the scan.
+func (v *view) ScanPage(start, end Bytes, cursor string, limit int) (ret []PageItem, next string, err error) {
+	defer v.metrics.observe("scanpage", time.Now(), &err)
+	keyStart, keyEnd := kvPageRange(start, end)
+	return v.scanPage(keyStart, keyEnd, FORWARD, cursor, limit, kvPageItem)
+}
+
+// RscanPage read a page like Rscan, see ScanPage
+func (v *view) RscanPage(start, end Bytes, cursor string, limit int) (ret []PageItem, next string, err error) {
+	defer v.metrics.observe("rscanpage", time.Now(), &err)
+	keyStart, keyEnd := kvPageRange(start, end)
+	return v.scanPage(keyStart, keyEnd, BACKWARD, cursor, limit, kvPageItem)
+}
+
+func exkvPageRange(start, end Bytes) (keyStart, keyEnd Bytes) {
+	keyStart, keyEnd = encodeExkvKey(start), encodeExkvKey(end)
+	if len(end) == 0 {
+		keyEnd = encodeOneKey(DTEXKV+1, end)
+	}
+	return keyStart, keyEnd
+}
+
+// EscanPage read a page like Escan, see ScanPage
+func (v *view) EscanPage(start, end Bytes, cursor string, limit int) (ret []PageItem, next string, err error) {
+	defer v.metrics.observe("escanpage", time.Now(), &err)
+	keyStart, keyEnd := exkvPageRange(start, end)
+	return v.scanPage(keyStart, keyEnd, FORWARD, cursor, limit, exkvPageItem)
+}
+
+// ErscanPage read a page like Erscan, see ScanPage
+func (v *view) ErscanPage(start, end Bytes, cursor string, limit int) (ret []PageItem, next string, err error) {
+	defer v.metrics.observe("erscanpage", time.Now(), &err)
+	keyStart, keyEnd := exkvPageRange(start, end)
+	return v.scanPage(keyStart, keyEnd, BACKWARD, cursor, limit, exkvPageItem)
+}
+
+func hashPageRange(name, start, end Bytes) (keyStart, keyEnd Bytes) {
+	keyStart, keyEnd = encodeHashKey(name, start), encodeHashKey(name, end)
+	if len(end) == 0 {
+		keyEnd = encodeTwoKey(DTHASH, name, 1, nil)
+	}
+	return keyStart, keyEnd
+}
+
+// HscanPage read a page like Hscan, see ScanPage
+func (v *view) HscanPage(name, start, end Bytes, cursor string, limit int) (ret []PageItem, next string, err error) {
+	defer v.metrics.observe("hscanpage", time.Now(), &err)
+	keyStart, keyEnd := hashPageRange(name, start, end)
+	return v.scanPage(keyStart, keyEnd, FORWARD, cursor, limit, hashPageItem)
+}
+
+// HrscanPage read a page like Hrscan, see ScanPage
+func (v *view) HrscanPage(name, start, end Bytes, cursor string, limit int) (ret []PageItem, next string, err error) {
+	defer v.metrics.observe("hrscanpage", time.Now(), &err)
+	keyStart, keyEnd := hashPageRange(name, start, end)
+	return v.scanPage(keyStart, keyEnd, BACKWARD, cursor, limit, hashPageItem)
+}
+
+// ZscanPage read a page of the members with their scores like Zscan, by score and member, see ScanPage
+func (v *view) ZscanPage(name Bytes, start, end int64, cursor string, limit int) (ret []PageItem, next string, err error) {
+	defer v.metrics.observe("zscanpage", time.Now(), &err)
+	return v.scanPage(encodeZscoreKey(name, nil, start), encodeZscoreKey(name, nil, end), FORWARD, cursor, limit, zsetPageItem)
+}
+
+// ZrscanPage read a page like Zrscan, see ZscanPage
+func (v *view) ZrscanPage(name Bytes, start, end int64, cursor string, limit int) (ret []PageItem, next string, err error) {
+	defer v.metrics.observe("zrscanpage", time.Now(), &err)
+	return v.scanPage(encodeZscoreKey(name, nil, start), encodeZscoreKey(name, nil, end), BACKWARD, cursor, limit, zsetPageItem)
+}
+
+// QscanPage read a page of the items like Qscan, from the back to the front, see ScanPage
+func (v *view) QscanPage(name Bytes, cursor string, limit int) (ret []PageItem, next string, err error) {
+	defer v.metrics.observe("qscanpage", time.Now(), &err)
+	return v.scanPage(encodeQitemKey(name, 0), encodeQitemKey(name, 0x7FFFFFFFffffffff), FORWARD, cursor, limit, queuePageItem)
+}
+
+// QrscanPage read a page of the items from the front to the back, see QscanPage
+func (v *view) QrscanPage(name Bytes, cursor string, limit int) (ret []PageItem, next string, err error) {
+	defer v.metrics.observe("qrscanpage", time.Now(), &err)
+	return v.scanPage(encodeQitemKey(name, 0), encodeQitemKey(name, 0x7FFFFFFFffffffff), BACKWARD, cursor, limit, queuePageItem)
+}
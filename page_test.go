@@ -0,0 +1,164 @@
+package emssdb
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// pageKeys return the keys of the items as strings
+func pageKeys(items []PageItem) (ret []string) {
+	for _, item := range items {
+		ret = append(ret, string(item.Key))
+	}
+	return ret
+}
+
+func TestScanPageResume(t *testing.T) {
+	d := openTestDB(t, Options{})
+	for i := 0; i < 10; i++ {
+		d.Set(Bytes(fmt.Sprintf("k%d", i)), Bytes("v"))
+	}
+	page := func(scan func(start, end Bytes, cursor string, limit int) ([]PageItem, string, error), cursor string) ([]string, string) {
+		items, next, err := scan(nil, nil, cursor, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pageKeys(items), next
+	}
+
+	// the changes behind the cursor are not seen, the ones after it are
+	got, cursor := page(d.ScanPage, "")
+	d.Del(Bytes("k2"))
+	d.Set(Bytes("k15"), Bytes("v"))
+	d.Set(Bytes("k25"), Bytes("v"))
+	d.Del(Bytes("k4"))
+	for cursor != "" {
+		var keys []string
+		keys, cursor = page(d.ScanPage, cursor)
+		got = append(got, keys...)
+	}
+	if want := "[k0 k1 k2 k25 k3 k5 k6 k7 k8 k9]"; fmt.Sprint(got) != want {
+		t.Fatal(got)
+	}
+
+	got, cursor = page(d.RscanPage, "")
+	d.Del(Bytes("k7"))
+	d.Set(Bytes("k8a"), Bytes("v"))
+	d.Set(Bytes("k65"), Bytes("v"))
+	d.Del(Bytes("k5"))
+	for cursor != "" {
+		var keys []string
+		keys, cursor = page(d.RscanPage, cursor)
+		got = append(got, keys...)
+	}
+	if want := "[k9 k8 k7 k65 k6 k3 k25 k15 k1 k0]"; fmt.Sprint(got) != want {
+		t.Fatal(got)
+	}
+}
+
+// TestPageConcurrentWrites check that the pages of a hash and a queue
+// resume in order while they are written
+func TestPageConcurrentWrites(t *testing.T) {
+	d := openTestDB(t, Options{})
+	for i := 0; i < 100; i++ {
+		d.Hset(Bytes("h"), Bytes(fmt.Sprintf("f%03d", 2*i)), Bytes("v"))
+		d.QpushBack(Bytes("q"), Bytes("v"))
+	}
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			// the odd fields come and go, the even ones stay
+			field := Bytes(fmt.Sprintf("f%03d", 2*(i%100)+1))
+			if i%200 < 100 {
+				d.Hset(Bytes("h"), field, Bytes("v"))
+			} else {
+				d.Hdel(Bytes("h"), field)
+			}
+			d.QpushBack(Bytes("q"), Bytes("v"))
+		}
+	}()
+	defer func() {
+		close(stop)
+		wg.Wait()
+	}()
+
+	var fields []string
+	for cursor, first := "", true; first || cursor != ""; first = false {
+		items, next, err := d.HscanPage(Bytes("h"), nil, nil, cursor, 7)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fields, cursor = append(fields, pageKeys(items)...), next
+	}
+	even := 0
+	for i, field := range fields {
+		if i > 0 && field <= fields[i-1] {
+			t.Fatalf("%q after %q", field, fields[i-1])
+		}
+		var n int
+		fmt.Sscanf(field, "f%d", &n)
+		if n%2 == 0 {
+			even++
+		}
+	}
+	if even != 100 {
+		t.Fatal("even fields", even)
+	}
+
+	// the queue pages go on over the items pushed meanwhile
+	var last int64 = -1
+	count := 0
+	for cursor, first := "", true; (first || cursor != "") && count < 1000; first = false {
+		items, next, err := d.QscanPage(Bytes("q"), cursor, 7)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, item := range items {
+			if item.Seq != last+1 && last >= 0 {
+				t.Fatalf("seq %d after %d", item.Seq, last)
+			}
+			last = item.Seq
+			count++
+		}
+		cursor = next
+	}
+	if count < 100 {
+		t.Fatal("queue items", count)
+	}
+}
+
+func TestBadCursor(t *testing.T) {
+	d := openTestDB(t, Options{})
+	for i := 0; i < 5; i++ {
+		d.Hset(Bytes("h"), Bytes(fmt.Sprint(i)), Bytes("v"))
+		d.Hset(Bytes("g"), Bytes(fmt.Sprint(i)), Bytes("v"))
+	}
+	_, cursor, err := d.HscanPage(Bytes("h"), nil, nil, "", 2)
+	if err != nil || cursor == "" {
+		t.Fatal(cursor, err)
+	}
+	if _, next, err := d.HscanPage(Bytes("h"), nil, nil, cursor, 0); err != nil || next != cursor {
+		t.Fatal(next, err)
+	}
+	for _, bad := range []string{"!", encodeCursor(7, Bytes("k")), encodeCursor(FORWARD, nil)} {
+		if _, _, err := d.HscanPage(Bytes("h"), nil, nil, bad, 2); err != ErrBadCursor {
+			t.Fatal(bad, err)
+		}
+	}
+	// the cursor of another scan
+	if _, _, err := d.HrscanPage(Bytes("h"), nil, nil, cursor, 2); err != ErrBadCursor {
+		t.Fatal(err)
+	}
+	if _, _, err := d.HscanPage(Bytes("g"), nil, nil, cursor, 2); err != ErrBadCursor {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,208 @@
+package emssdb
+
+import (
+	"encoding/binary"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+)
+
+// RecoverReport the result of RecoverDB
+type RecoverReport struct {
+	Keys          int64        // keys left after the recovery
+	Unknown       int64        // keys of no data type, deleted
+	UnknownKeys   []Bytes      // the first checkMaxIssues of them
+	FormatLost    bool         // the format version was missing, Format is guessed from the keys
+	Format        int          // the format version the db was found in
+	DroppedTables bool         // the tables with corrupted blocks were dropped whole
+	Check         *CheckReport // the mismatches left by the lost keys, repaired
+}
+
+// RecoverDB open a db whose manifest or tables are corrupted.
+// The manifest is rebuilt from the tables by leveldb, the keys of no data type are deleted,
+// and then the db is opened and repaired.
+// The keys in the corrupted parts of the tables are lost, the mismatches in the Check
+// of the report tell the containers which lost some of them.
+func RecoverDB(options Options) (that *DB, ret *RecoverReport, err error) {
+	if options.ReadOnly {
+		return nil, nil, ErrReadOnly
+	}
+	o := leveldbOptions(options)
+	o.ErrorIfExist = false
+	rep := &RecoverReport{}
+	ldb, err := leveldb.RecoverFile(options.Path, &o)
+	if err != nil {
+		// the tables with corrupted blocks could not be rebuilt, drop them whole
+		o.Strict = opt.DefaultStrict | opt.StrictRecovery
+		if ldb, err = leveldb.RecoverFile(options.Path, &o); err != nil {
+			return nil, nil, err
+		}
+		rep.DroppedTables = true
+	}
+	err = rep.validate(ldb)
+	if cerr := ldb.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, rep, err
+	}
+
+	options.ErrorIfExist = false
+	d, err := OpenDB(options)
+	if err != nil {
+		return nil, rep, err
+	}
+	if rep.Check, err = d.Repair(); err != nil {
+		d.Close()
+		return nil, rep, err
+	}
+	return d, rep, nil
+}
+
+// validate delete the keys of no data type, and keep the format version
+func (rep *RecoverReport) validate(ldb *leveldb.DB) (err error) {
+	val, err := ldb.Get(encodeMetaKey("format"), nil)
+	rep.Format = int(Bytes(val).GetUInt64())
+	lost := err == leveldb.ErrNotFound
+	if lost {
+		if rep.Format, err = guessFormat(ldb); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+
+	w := &bulkWriter{db: ldb}
+	it := ldb.NewIterator(nil, &opt.ReadOptions{DontFillCache: true})
+	defer it.Release()
+	for it.Next() {
+		if knownLayoutPrefix(rep.Format, it.Key()) {
+			rep.Keys++
+			continue
+		}
+		rep.Unknown++
+		if len(rep.UnknownKeys) < checkMaxIssues {
+			rep.UnknownKeys = append(rep.UnknownKeys, NewByClone(it.Key()))
+		}
+		if err = w.delete(it.Key()); err != nil {
+			return err
+		}
+	}
+	if err = it.Error(); err != nil {
+		return err
+	}
+	if err = w.flush(); err != nil {
+		return err
+	}
+
+	// record the guessed format version so OpenDB migrates the db from it
+	if !lost || rep.Keys == 0 {
+		return nil
+	}
+	rep.FormatLost = true
+	return writeFormat(ldb, rep.Format)
+}
+
+// knownLayoutPrefix tell whether the raw key of the layout version is of one of the data types,
+// the namespaces of the layouts 1 and 2 have a length byte
+func knownLayoutPrefix(version int, key Bytes) bool {
+	if version >= 3 || len(key) == 0 || key[0] != DTNAMESPACE {
+		return knownPrefix(key)
+	}
+	prefix, nkey := splitNamespaceV1(key)
+	return len(prefix) > 3 && len(nkey) > 0 && nkey[0] != DTMETA && nkey[0] != DTNAMESPACE && knownPrefix(nkey)
+}
+
+// guessFormat tell the format version of a db which lost it from the shapes of its keys:
+// the layout fitted by the most keys, the newest one of a tie, 0 for a db without keys.
+// The layouts 3 and 4 have the same shapes, a db of the layout 4 has reserved the epochs
+// of its version stamps in the meta key "epoch".
+func guessFormat(ldb *leveldb.DB) (version int, err error) {
+	var misfits [4]int64
+	keys := 0
+	it := ldb.NewIterator(nil, &opt.ReadOptions{DontFillCache: true})
+	defer it.Release()
+	for it.Next() {
+		if it.Key()[0] == DTMETA {
+			continue
+		}
+		keys++
+		for v := 1; v <= 3; v++ {
+			if !layoutFits(v, it.Key()) {
+				misfits[v]++
+			}
+		}
+	}
+	if err = it.Error(); err != nil || keys == 0 {
+		return 0, err
+	}
+	version = 3
+	for v := 2; v >= 1; v-- {
+		if misfits[v] < misfits[version] {
+			version = v
+		}
+	}
+	if version < 3 {
+		return version, nil
+	}
+	if _, err = ldb.Get(encodeMetaKey("epoch"), nil); err == nil {
+		return 4, nil
+	} else if err != leveldb.ErrNotFound {
+		return 0, err
+	}
+	return 3, nil
+}
+
+// layoutFits tell whether the raw key has the shape of the layout version, 1 to 3:
+// the prefix of its namespace, and the name of a hash, a zset or a queue followed by a 0.
+// In the layout 1 the queue names have no length, in the layout 2 the queue names
+// over 255 bytes have the uvarint length of the layout 3, see migrateQueueNames.
+func layoutFits(version int, key Bytes) bool {
+	var prefix Bytes
+	if version < 3 {
+		prefix, key = splitNamespaceV1(key)
+	} else {
+		prefix, key = splitNamespaceV3(key)
+	}
+	if len(key) == 0 || key[0] == DTNAMESPACE || (prefix != nil && prefix[len(prefix)-1] != 0) {
+		return false
+	}
+	switch key[0] {
+	case DTHASH, DTZSET:
+		end := nameEnd(version, key)
+		return end > 0 && end < len(key)
+	case DTZSCORE:
+		end := nameEnd(version, key)
+		return end > 0 && end+9 <= len(key)
+	case DTQUEUE:
+		switch version {
+		case 1:
+			return len(key) >= 10 && key[len(key)-9] == 0
+		case 2:
+			if isQueueKeyV2(key) {
+				return key[len(key)-9] == 0
+			}
+			return len(key) > SSDB_KEY_LEN_MAX+10 && nameEnd(3, key)+9 == len(key)
+		}
+		end := nameEnd(version, key)
+		return end > 0 && end+9 == len(key)
+	}
+	return true
+}
+
+// nameEnd return the index of the 0 after the name of [DT][len(name)][name][0][...]
+// in the layout version, with a byte length before the layout 3, -1 if there is none
+func nameEnd(version int, key Bytes) (ret int) {
+	if len(key) < 3 {
+		return -1
+	}
+	length, n := uint64(key[1]), 1
+	if version >= 3 {
+		if length, n = binary.Uvarint(key[1:]); n <= 0 {
+			return -1
+		}
+	}
+	if length >= uint64(len(key)) || 1+n+int(length) >= len(key) || key[1+n+int(length)] != 0 {
+		return -1
+	}
+	return 1 + n + int(length)
+}
@@ -0,0 +1,113 @@
+package emssdb
+
+import (
+	"github.com/syndtr/goleveldb/leveldb"
+	"strings"
+	"testing"
+)
+
+// writeRawDB write the raw keys into a new leveldb at path
+func writeRawDB(t *testing.T, path string, keys map[string]Bytes) {
+	t.Helper()
+	ldb, err := leveldb.OpenFile(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ldb.Close()
+	for key, val := range keys {
+		if err = ldb.Put(Bytes(key), val, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestRecoverLegacyFormat(t *testing.T) {
+	path := t.TempDir()
+	keys := map[string]Bytes{}
+	queueKeysOf(1, keys, "", "q", "a", "b")
+	writeRawDB(t, path, keys)
+
+	d, rep, err := RecoverDB(Options{Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	if !rep.FormatLost || rep.Format != 1 || d.FormatVersion() != formatVersion {
+		t.Fatal(rep.FormatLost, rep.Format, d.FormatVersion())
+	}
+	if item, err := d.Qfront(Bytes("q")); err != nil || string(item) != "a" {
+		t.Fatal(item, err)
+	}
+	if item, err := d.Qback(Bytes("q")); err != nil || string(item) != "b" {
+		t.Fatal(item, err)
+	}
+	checkClean(t, d)
+}
+
+func TestRecoverGuessFormat(t *testing.T) {
+	names := append(migrateNames, strings.Repeat("q", 300))
+	for _, version := range []int{1, 2} {
+		path := t.TempDir()
+		keys, want := legacyKeyspace(version, names)
+		delete(keys, string(encodeMetaKey("format")))
+		writeRawDB(t, path, keys)
+
+		d, rep, err := RecoverDB(Options{Path: path})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !rep.FormatLost || rep.Format != version || rep.Unknown != 0 || rep.Check.Problems != 0 {
+			t.Fatalf("layout %d: %+v %+v", version, rep, rep.Check)
+		}
+		checkMigrated(t, d, names, want)
+		d.Close()
+	}
+}
+
+func TestRecoverCurrentFormat(t *testing.T) {
+	path := t.TempDir()
+	long := Bytes(strings.Repeat("h", 200))
+	d, err := OpenDB(Options{Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ns, _ := d.Namespace(strings.Repeat("n", 130))
+	for _, h := range []*DB{d, ns} {
+		h.QpushBack(Bytes("q"), Bytes("a"))
+		h.QpushBack(long, Bytes("b"))
+		h.Hset(long, Bytes("f"), Bytes("v"))
+		h.Zset(long, Bytes("m"), 7)
+	}
+	d.Close()
+	ldb, err := leveldb.OpenFile(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ldb.Delete(encodeMetaKey("format"), nil)
+	ldb.Close()
+
+	d, rep, err := RecoverDB(Options{Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	if !rep.FormatLost || rep.Format != formatVersion || rep.Unknown != 0 || rep.Check.Problems != 0 {
+		t.Fatalf("%+v %+v", rep, rep.Check)
+	}
+	ns, _ = d.Namespace(strings.Repeat("n", 130))
+	for _, h := range []*DB{d, ns} {
+		if item, err := h.Qfront(Bytes("q")); err != nil || string(item) != "a" {
+			t.Fatal(item, err)
+		}
+		if item, err := h.Qfront(long); err != nil || string(item) != "b" {
+			t.Fatal(item, err)
+		}
+		if val, err := h.Hget(long, Bytes("f")); err != nil || string(val) != "v" {
+			t.Fatal(val, err)
+		}
+		if score, err := h.Zget(long, Bytes("m")); err != nil || score != 7 {
+			t.Fatal(score, err)
+		}
+	}
+	checkClean(t, d)
+}
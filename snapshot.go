@@ -0,0 +1,55 @@
+package emssdb
+
+import (
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/iterator"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+	"time"
+)
+
+// reader is the read side of leveldb, both leveldb.DB and leveldb.Snapshot are readers
+type reader interface {
+	Get(key []byte, ro *opt.ReadOptions) (value []byte, err error)
+	NewIterator(slice *util.Range, ro *opt.ReadOptions) iterator.Iterator
+}
+
+// view holds the typed read operations (Get, Hget, Zscan, Qsize ...) over a reader
+type view struct {
+	r         reader
+	metrics   *metrics // nil for the views not measured, as Tx
+	maxKeyLen int      // Options.MaxKeyLen
+}
+
+// Snapshot a read-only and consistent view of the DB at a moment
+type Snapshot struct {
+	view
+	snap *leveldb.Snapshot
+}
+
+// Snapshot return a snapshot of the current DB, Release it after use
+func (d *DB) Snapshot() (ret *Snapshot, err error) {
+	defer d.metrics.observe("snapshot", time.Now(), &err)
+	if ret, err = d.snapshot(); err == nil {
+		ret.metrics = d.metrics
+	}
+	return ret, err
+}
+
+// snapshot is Snapshot for the internal use, its reads are not measured by the metrics
+func (d *DB) snapshot() (ret *Snapshot, err error) {
+	snap, err := d.db.GetSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	var s Snapshot
+	s.snap = snap
+	s.r = d.prefixed(&countReader{snap, d.counters})
+	s.maxKeyLen = d.maxKeyLen
+	return &s, nil
+}
+
+// Release release the snapshot, the iterators of it should be closed before
+func (s *Snapshot) Release() {
+	s.snap.Release()
+}
@@ -0,0 +1,59 @@
+package emssdb
+
+import "testing"
+
+func TestSnapshotIsolation(t *testing.T) {
+	d := openTestDB(t, Options{})
+	ns, _ := d.Namespace("ns")
+	for _, h := range []*DB{d, ns} {
+		h.Set(Bytes("k"), Bytes("v1"))
+		h.Hset(Bytes("h"), Bytes("a"), Bytes("1"))
+		h.Zset(Bytes("z"), Bytes("m"), 1)
+		h.QpushBack(Bytes("q"), Bytes("x"))
+
+		s, err := h.Snapshot()
+		if err != nil {
+			t.Fatal(err)
+		}
+		h.Set(Bytes("k"), Bytes("v2"))
+		h.Hset(Bytes("h"), Bytes("b"), Bytes("2"))
+		h.Zdel(Bytes("z"), Bytes("m"))
+		h.QpushBack(Bytes("q"), Bytes("y"))
+
+		if val, err := s.Get(Bytes("k")); err != nil || string(val) != "v1" {
+			t.Fatal("get", val, err)
+		}
+		if size, _ := s.Hsize(Bytes("h")); size != 1 {
+			t.Fatal("hsize", size)
+		}
+		n := 0
+		hit := s.Hscan(Bytes("h"), nil, nil)
+		for hit.Next() {
+			n++
+		}
+		hit.Close()
+		if n != 1 {
+			t.Fatal("hscan", n)
+		}
+		if score, err := s.Zget(Bytes("z"), Bytes("m")); err != nil || score != 1 {
+			t.Fatal("zget", score, err)
+		}
+		if size, _ := s.Qsize(Bytes("q")); size != 1 {
+			t.Fatal("qsize", size)
+		}
+		if item, err := s.Qback(Bytes("q")); err != nil || string(item) != "x" {
+			t.Fatal("qback", item, err)
+		}
+		s.Release()
+
+		if val, _ := h.Get(Bytes("k")); string(val) != "v2" {
+			t.Fatal("live get", val)
+		}
+	}
+	// the snapshot of the DB does not see the namespaces
+	s, _ := d.Snapshot()
+	defer s.Release()
+	if list := s.Hlist(nil, nil); len(list) != 1 {
+		t.Fatalf("hlist %q", list)
+	}
+}
@@ -0,0 +1,164 @@
+package emssdb
+
+import (
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/iterator"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+	"sync/atomic"
+	"time"
+)
+
+// opCounters count the operations of a DB, updated atomically.
+// The reads of the DB, its snapshots and watches are counted, not the internal
+// ones of the Tx, which read the sizes and the old values of their writes.
+type opCounters struct {
+	reads     int64 // point reads
+	scans     int64 // iterators opened
+	writes    int64 // committed writes
+	openIters int64 // iterators not released yet
+}
+
+// countReader count the reads of a reader
+type countReader struct {
+	reader
+	c *opCounters
+}
+
+func (r *countReader) Get(key []byte, ro *opt.ReadOptions) (value []byte, err error) {
+	atomic.AddInt64(&r.c.reads, 1)
+	return r.reader.Get(key, ro)
+}
+
+func (r *countReader) NewIterator(slice *util.Range, ro *opt.ReadOptions) iterator.Iterator {
+	atomic.AddInt64(&r.c.scans, 1)
+	atomic.AddInt64(&r.c.openIters, 1)
+	return &countIterator{Iterator: r.reader.NewIterator(slice, ro), c: r.c}
+}
+
+// countIterator count itself out of the open iterators when released
+type countIterator struct {
+	iterator.Iterator
+	c        *opCounters
+	released int32
+}
+
+func (it *countIterator) Release() {
+	if atomic.CompareAndSwapInt32(&it.released, 0, 1) {
+		atomic.AddInt64(&it.c.openIters, -1)
+	}
+	it.Iterator.Release()
+}
+
+// LevelStats the tables of a level of leveldb
+type LevelStats struct {
+	Tables   int
+	Size     int64 // bytes
+	Read     int64 // bytes read by the compactions
+	Write    int64 // bytes written by the compactions
+	Duration time.Duration
+}
+
+// Stats the statistics of a DB
+type Stats struct {
+	Levels   []LevelStats
+	SSTables string // the tables of each level, as leveldb.sstables
+
+	// approximate bytes on disk of each data type, with its indexes:
+	// DTKV, DTEXKV (and the expire stamps), DTHASH, DTZSET, DTQUEUE
+	Sizes map[byte]int64
+
+	// counted by Stats(true) only, it scans all the sizes and the expire stamps
+	Hashes        int64 // number of the hashes
+	Zsets         int64 // number of the zsets
+	Queues        int64 // number of the queues
+	PendingExpire int64 // number of the exkv waiting for their expiration
+	DueExpire     int64 // of them, the ones already due for the expire daemon
+
+	Reads         int64 // point reads
+	Scans         int64 // iterators opened
+	Writes        int64 // committed writes
+	OpenIterators int64 // iterators not released yet, of emssdb
+
+	AliveIterators     int32 // of leveldb, with the internal ones
+	AliveSnapshots     int32
+	WriteDelayCount    int32
+	WriteDelayDuration time.Duration
+	IORead             uint64
+	IOWrite            uint64
+}
+
+// the prefixes of the keys of each data type in Stats.Sizes
+var statsPrefixes = map[byte][]byte{
+	DTKV:    {DTKV},
+	DTEXKV:  {DTEXKV, DTEXSTAMP},
+	DTHASH:  {DTHASH, DTHSIZE},
+	DTZSET:  {DTZSET, DTZSCORE, DTZSIZE},
+	DTQUEUE: {DTQUEUE, DTQSIZE},
+}
+
+// Stats return the statistics of the db. With counts it counts the containers and
+// the exkv by a scan of their keys, too slow to be polled on a large db.
+func (d *DB) Stats(counts bool) (ret *Stats, err error) {
+	var ls leveldb.DBStats
+	if err = d.db.Stats(&ls); err != nil {
+		return nil, err
+	}
+	var s Stats
+	for level := range ls.LevelSizes {
+		s.Levels = append(s.Levels, LevelStats{
+			Tables:   ls.LevelTablesCounts[level],
+			Size:     ls.LevelSizes[level],
+			Read:     ls.LevelRead[level],
+			Write:    ls.LevelWrite[level],
+			Duration: ls.LevelDurations[level],
+		})
+	}
+	if s.SSTables, err = d.db.GetProperty("leveldb.sstables"); err != nil {
+		return nil, err
+	}
+
+	s.Sizes = make(map[byte]int64)
+	for dt, prefixes := range statsPrefixes {
+		var ranges []util.Range
+		for _, p := range prefixes {
+			ranges = append(ranges, util.Range{Start: []byte{p}, Limit: []byte{p + 1}})
+		}
+		sizes, err := d.db.SizeOf(ranges)
+		if err != nil {
+			return nil, err
+		}
+		s.Sizes[dt] = sizes.Sum()
+	}
+
+	if counts {
+		s.Hashes = d.countKeys(encodeOneKey(DTHSIZE, nil), encodeOneKey(DTHSIZE+1, nil))
+		s.Zsets = d.countKeys(encodeOneKey(DTZSIZE, nil), encodeOneKey(DTZSIZE+1, nil))
+		s.Queues = d.countKeys(encodeOneKey(DTQSIZE, nil), encodeOneKey(DTQSIZE+1, nil))
+		s.PendingExpire = d.countKeys(encodeOneKey(DTEXSTAMP, nil), encodeOneKey(DTEXSTAMP+1, nil))
+		s.DueExpire = d.countKeys(encodeOneKey(DTEXSTAMP, nil), encodeExstampKey(nil, uint64(time.Now().Unix())+1))
+	}
+
+	s.Reads = atomic.LoadInt64(&d.counters.reads)
+	s.Scans = atomic.LoadInt64(&d.counters.scans)
+	s.Writes = atomic.LoadInt64(&d.counters.writes)
+	s.OpenIterators = atomic.LoadInt64(&d.counters.openIters)
+
+	s.AliveIterators = ls.AliveIterators
+	s.AliveSnapshots = ls.AliveSnapshots
+	s.WriteDelayCount = ls.WriteDelayCount
+	s.WriteDelayDuration = ls.WriteDelayDuration
+	s.IORead = ls.IORead
+	s.IOWrite = ls.IOWrite
+	return &s, nil
+}
+
+// countKeys count the keys in [start, limit), not counted as scans
+func (d *DB) countKeys(start, limit Bytes) (ret int64) {
+	it := d.db.NewIterator(&util.Range{Start: start, Limit: limit}, &opt.ReadOptions{DontFillCache: true})
+	defer it.Release()
+	for it.Next() {
+		ret++
+	}
+	return ret
+}
@@ -0,0 +1,24 @@
+package emssdb
+
+import "testing"
+
+func TestStats(t *testing.T) {
+	d := openTestDB(t, Options{})
+	d.Hset(Bytes("h"), Bytes("f"), Bytes("v"))
+	d.Zset(Bytes("z"), Bytes("m"), 1)
+	d.Hget(Bytes("h"), Bytes("f"))
+
+	s, err := d.Stats(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Reads != 1 {
+		t.Fatal("reads", s.Reads)
+	}
+	if s.Hashes != 0 || s.Zsets != 0 {
+		t.Fatal("counted", s.Hashes, s.Zsets)
+	}
+	if s, err = d.Stats(true); err != nil || s.Hashes != 1 || s.Zsets != 1 || s.Queues != 0 {
+		t.Fatal(s, err)
+	}
+}
@@ -37,100 +37,140 @@ func decodeExstampKey(slice Bytes) (ret Bytes, stamp uint64) {
 }
 
 func (db *DB) Eset(key, val Bytes, etime uint64) (err error) {
+	defer db.metrics.observe("eset", time.Now(), &err)
+	return db.update(DTEXKV, key, func(tx *Tx) error {
+		return tx.Eset(key, val, etime)
+	})
+}
+
+func (db *DB) Edel(key Bytes) (err error) {
+	defer db.metrics.observe("edel", time.Now(), &err)
+	return db.update(DTEXKV, key, func(tx *Tx) error {
+		return tx.Edel(key)
+	})
+}
+
+func (tx *Tx) Eset(key, val Bytes, etime uint64) (err error) {
 	if len(key) == 0 {
 		return ErrEmptyKey
 	}
-	db.Edel(key)
+	tx.touch(DTEXKV, key)
+	tx.Edel(key)
 
-	writer := db.writer
-	writer.Do()
-	defer writer.Done()
-	// readoption
 	ekey := encodeExkvKey(key)
 	eval := encodeExkvValue(val, etime)
-	writer.Put(ekey, eval)
+	tx.put(ekey, eval)
 	xkey := encodeExstampKey(key, etime)
-	writer.Put(xkey, nil)
-	return writer.Commit()
+	tx.put(xkey, nil)
+	return nil
 }
 
-func (db *DB) Edel(key Bytes) (err error) {
-	writer := db.writer
-	writer.Do()
-	defer writer.Done()
-	// readoption
-	_, etime, _ := db.Eget(key)
+func (tx *Tx) Edel(key Bytes) (err error) {
+	tx.touch(DTEXKV, key)
+	_, etime, _ := tx.Eget(key)
 	ekey := encodeExkvKey(key)
-	writer.Delete(ekey)
+	tx.delete(ekey)
 	xkey := encodeExstampKey(key, etime)
-	writer.Delete(xkey)
-	return writer.Commit()
+	tx.delete(xkey)
+	return nil
 }
 
 func (db *DB) EsetDelay(delay time.Duration) {
 	db.expireDelay = delay
 }
 
-func (db *DB) Eget(key Bytes) (ret Bytes, stamp uint64, err error) {
+func (v *view) Eget(key Bytes) (ret Bytes, stamp uint64, err error) {
+	defer v.metrics.observe("eget", time.Now(), &err)
 	// readoption
 	rkey := encodeExkvKey(key)
-	slice, _ := db.db.Get(rkey, nil)
+	slice, _ := v.r.Get(rkey, nil)
 	if len(slice) < 8 {
 		return nil, 0, nil
 	}
-	v, s := decodeExkvValue(slice)
-	return v, s, nil
+	val, s := decodeExkvValue(slice)
+	return val, s, nil
 }
 
-func (db *DB) Escan(start Bytes, end Bytes) (ret *EIterator) {
+func (v *view) Escan(start Bytes, end Bytes) (ret *EIterator) {
+	defer v.metrics.observe("escan", time.Now(), nil)
 	keyStart, keyEnd := encodeExkvKey(start), encodeExkvKey(end)
 	if len(end) == 0 {
 		keyEnd = encodeOneKey(DTEXKV+1, end)
 	}
-	return NewEIterator(db.Iterator(keyStart, keyEnd))
+	return NewEIterator(v.Iterator(keyStart, keyEnd))
 }
 
-func (db *DB) Erscan(start Bytes, end Bytes) (ret *EIterator) {
+func (v *view) Erscan(start Bytes, end Bytes) (ret *EIterator) {
+	defer v.metrics.observe("erscan", time.Now(), nil)
 	keyStart, keyEnd := encodeExkvKey(start), encodeExkvKey(end)
 	if len(end) == 0 {
 		keyEnd = encodeOneKey(DTEXKV+1, end)
 	}
-	return NewEIterator(db.RevIterator(keyStart, keyEnd))
+	return NewEIterator(v.RevIterator(keyStart, keyEnd))
 }
 
-func (db *DB) Elist(start uint64, end uint64) (ret *XIterator) {
+func (v *view) Elist(start uint64, end uint64) (ret *XIterator) {
+	defer v.metrics.observe("elist", time.Now(), nil)
+	return v.elist(start, end)
+}
+
+func (v *view) elist(start uint64, end uint64) (ret *XIterator) {
 	if end < start {
 		end = start + 1
 	}
 	keyStart, keyEnd := encodeExstampKey(nil, start), encodeExstampKey(nil, end)
-	return NewXIterator(db.Iterator(keyStart, keyEnd))
+	return NewXIterator(v.Iterator(keyStart, keyEnd))
 }
 
 func (db *DB) expireDaemon() {
-	db.waitgroup.Add(1)
+	defer db.waitgroup.Done()
 
 	if db.expireDelay >= time.Second {
-		for !db.end {
+		for {
 			now := time.Now().Unix()
-			xit := db.Elist(0, uint64(now))
-			for xit.Next() {
-				// db.Edel(xit.Key())
-				writer := db.writer
-				writer.Do()
-				// readoption
-				key := xit.Key()
-				_, etime, _ := db.Eget(key)
-				if etime == xit.Etime() {
-					ekey := encodeExkvKey(key)
-					writer.Delete(ekey)
+			expired, lag := db.expireKeys(now)
+			// every namespace has its own expire stamps
+			for _, name := range namespaceNames(db.db) {
+				if ns, err := db.Namespace(name); err == nil {
+					nexpired, nlag := ns.expireKeys(now)
+					expired += nexpired
+					if nlag > lag {
+						lag = nlag
+					}
 				}
-				xkey := encodeExstampKey(key, etime)
-				writer.Delete(xkey)
-				writer.Commit()
-				writer.Done()
 			}
-			time.Sleep(db.expireDelay)
+			db.metrics.expireRound(expired, lag)
+			select {
+			case <-db.quit:
+				return
+			case <-time.After(db.expireDelay):
+			}
+		}
+	}
+}
+
+// expireKeys delete the exkv due at now, and return the number of them
+// and how long the oldest one waited
+func (db *DB) expireKeys(now int64) (expired, lag int64) {
+	xit := db.elist(0, uint64(now))
+	defer xit.Close()
+	for xit.Next() {
+		// db.Edel(xit.Key())
+		key, xetime := xit.Key(), xit.Etime()
+		if lag == 0 {
+			// the stamps are in the order of etime, the first is the oldest
+			lag = now - int64(xetime)
 		}
+		db.update(DTEXKV, key, func(tx *Tx) error {
+			_, etime, _ := tx.Eget(key)
+			if etime == xetime {
+				tx.touch(DTEXKV, key)
+				tx.delete(encodeExkvKey(key))
+				expired++
+			}
+			tx.delete(encodeExstampKey(key, xetime))
+			return nil
+		})
 	}
-	db.waitgroup.Done()
+	return expired, lag
 }
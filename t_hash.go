@@ -3,6 +3,7 @@ package emssdb
 import (
 	"bytes"
 	"github.com/syndtr/goleveldb/leveldb"
+	"time"
 )
 
 func encodeHsizeKey(name Bytes) (ret Bytes) {
@@ -21,60 +22,74 @@ func decodeHashKey(slice Bytes) (name, key Bytes) {
 	return decodeTwoKey(slice)
 }
 
-func (db *DB) Hget(name, key Bytes) (val Bytes, err error) {
+func (v *view) Hget(name, key Bytes) (val Bytes, err error) {
+	defer v.metrics.observe("hget", time.Now(), &err)
 	// readoption
 	if verr := isVaildHashKey(name, key); verr != nil {
 		return nil, verr
 	}
 	rkey := encodeHashKey(name, key)
-	return db.db.Get(rkey, nil)
+	return v.r.Get(rkey, nil)
 }
 
 func (db *DB) Hset(name, key, val Bytes) (err error) {
-	if verr := isVaildHashKey(name, key); verr != nil {
+	defer db.metrics.observe("hset", time.Now(), &err)
+	return db.update(DTHASH, name, func(tx *Tx) error {
+		return tx.Hset(name, key, val)
+	})
+}
+
+func (db *DB) Hdel(name, key Bytes) (err error) {
+	defer db.metrics.observe("hdel", time.Now(), &err)
+	return db.update(DTHASH, name, func(tx *Tx) error {
+		return tx.Hdel(name, key)
+	})
+}
+
+func (db *DB) Hincr(name, key Bytes, by int64) (newval int64, err error) {
+	defer db.metrics.observe("hincr", time.Now(), &err)
+	err = db.update(DTHASH, name, func(tx *Tx) (err error) {
+		newval, err = tx.Hincr(name, key, by)
+		return err
+	})
+	return newval, err
+}
+
+func (tx *Tx) Hset(name, key, val Bytes) (err error) {
+	tx.touch(DTHASH, name)
+	if verr := tx.isVaildNewHashKey(name, key); verr != nil {
 		return verr
 	}
-	writer := db.writer
-	writer.Do()
-	defer writer.Done()
-	// readoption
-	if st := db.hsetOne(name, key, val); st == StatSucChange {
-		if err := db.hincrSize(name, 1); err != nil {
+	if st := tx.hsetOne(name, key, val); st == StatSucChange {
+		if err := tx.hincrSize(name, 1); err != nil {
 			return err
 		}
 	} else if st == StatSuccess {
 	} else {
 		return st
 	}
-	return writer.Commit()
+	return nil
 }
 
-func (db *DB) Hdel(name, key Bytes) (err error) {
+func (tx *Tx) Hdel(name, key Bytes) (err error) {
+	tx.touch(DTHASH, name)
 	if verr := isVaildHashKey(name, key); verr != nil {
 		return verr
 	}
-	writer := db.writer
-	writer.Do()
-	defer writer.Done()
-	// readoption
-	if st := db.hdelOne(name, key); st == StatSucChange {
-		db.hincrSize(name, -1)
+	if st := tx.hdelOne(name, key); st == StatSucChange {
+		return tx.hincrSize(name, -1)
 	}
-	return writer.Commit()
+	return nil
 }
 
-func (db *DB) Hincr(name, key Bytes, by int64) (newval int64, err error) {
-	if verr := isVaildHashKey(name, key); verr != nil {
+func (tx *Tx) Hincr(name, key Bytes, by int64) (newval int64, err error) {
+	tx.touch(DTHASH, name)
+	if verr := tx.isVaildNewHashKey(name, key); verr != nil {
 		return 0, verr
 	}
-	writer := db.writer
-	writer.Do()
-	defer writer.Done()
-	// readoption
 	var ival int64
-	if oldvar, oerr := db.Hget(name, key); oerr == leveldb.ErrNotFound {
+	if oldvar, oerr := tx.Hget(name, key); oerr == leveldb.ErrNotFound {
 		ival = by
-		db.hincrSize(name, 1)
 	} else if oerr == nil {
 		ival = oldvar.GetInt64() + by
 	} else {
@@ -83,42 +98,49 @@ func (db *DB) Hincr(name, key Bytes, by int64) (newval int64, err error) {
 
 	buf := NewByInt64(ival)
 
-	if st := db.hsetOne(name, key, buf); st == StatSuccess || st == StatSucChange {
-		return ival, writer.Commit()
+	if st := tx.hsetOne(name, key, buf); st == StatSucChange {
+		return ival, tx.hincrSize(name, 1)
+	} else if st == StatSuccess {
+		return ival, nil
 	} else {
 		return ival, st
 	}
 }
 
-func (db *DB) Hsize(name Bytes) (ret int64, err error) {
+func (v *view) Hsize(name Bytes) (ret int64, err error) {
+	defer v.metrics.observe("hsize", time.Now(), &err)
 	skey := encodeHsizeKey(name)
 	// readoption
-	ssize, serr := db.db.Get(skey, nil)
+	ssize, serr := v.r.Get(skey, nil)
 	return Bytes(ssize).GetInt64(), serr
 }
 
-func (db *DB) Hscan(name, start, end Bytes) (ret *HIterator) {
+func (v *view) Hscan(name, start, end Bytes) (ret *HIterator) {
+	defer v.metrics.observe("hscan", time.Now(), nil)
 	keyStart, keyEnd := encodeHashKey(name, start), encodeHashKey(name, end)
 	if len(end) == 0 {
 		keyEnd = encodeTwoKey(DTHASH, name, 1, nil)
 	}
-	return NewHIterator(db.Iterator(keyStart, keyEnd))
+	return NewHIterator(v.Iterator(keyStart, keyEnd))
 }
 
-func (db *DB) Hrscan(name, start, end Bytes) (ret *HIterator) {
+func (v *view) Hrscan(name, start, end Bytes) (ret *HIterator) {
+	defer v.metrics.observe("hrscan", time.Now(), nil)
 	keyStart, keyEnd := encodeHashKey(name, start), encodeHashKey(name, end)
 	if len(end) == 0 {
 		keyEnd = encodeTwoKey(DTHASH, name, 1, nil)
 	}
-	return NewHIterator(db.RevIterator(keyStart, keyEnd))
+	return NewHIterator(v.RevIterator(keyStart, keyEnd))
 }
 
-func (db *DB) Hlist(sname, ename Bytes) (ret []Bytes) {
+func (v *view) Hlist(sname, ename Bytes) (ret []Bytes) {
+	defer v.metrics.observe("hlist", time.Now(), nil)
 	start, end := encodeHsizeKey(sname), encodeHsizeKey(ename)
 	if len(ename) == 0 {
 		end = encodeOneKey(DTHSIZE+1, ename)
 	}
-	it := db.Iterator(start, end)
+	it := v.Iterator(start, end)
+	defer it.Close()
 	list := make([]Bytes, 0)
 	for it.Next() {
 		ks := it.Key()
@@ -127,45 +149,71 @@ func (db *DB) Hlist(sname, ename Bytes) (ret []Bytes) {
 	return list
 }
 
-func (db *DB) hsetOne(name, key, val Bytes) (ret Status) {
-	writer := db.writer
-	if dbval, hgerr := db.Hget(name, key); hgerr != nil {
+func (tx *Tx) hsetOne(name, key, val Bytes) (ret Status) {
+	if dbval, hgerr := tx.Hget(name, key); hgerr != nil {
 		hkey := encodeHashKey(name, key)
-		writer.Put(hkey, val)
+		tx.put(hkey, val)
 		return StatSucChange
 	} else {
 		if bytes.Compare(dbval, val) != 0 {
 			hkey := encodeHashKey(name, key)
-			writer.Put(hkey, val)
+			tx.put(hkey, val)
 		}
 		return StatSuccess
 	}
 }
 
-func (db *DB) hdelOne(name, key Bytes) (ret Status) {
+func (tx *Tx) hdelOne(name, key Bytes) (ret Status) {
 	if len(key) == 0 || len(name) == 0 {
 		return ErrEmptyKey
 	}
-	writer := db.writer
-	if _, hgerr := db.Hget(name, key); hgerr == nil {
+	if _, hgerr := tx.Hget(name, key); hgerr == nil {
 		hkey := encodeHashKey(name, key)
-		writer.Delete(hkey)
+		tx.delete(hkey)
 		return StatSucChange
 	} else {
 		return StatNotFound
 	}
 }
 
-func (db *DB) hincrSize(name Bytes, incr int64) (ret error) {
-	writer := db.writer
-	if isize, ierr := db.Hsize(name); ierr == nil || ierr == leveldb.ErrNotFound {
+// Hclear delete all the fields of the hash name in batches, and return the number of them.
+// The fields set meanwhile before the ones already deleted are kept.
+func (db *DB) Hclear(name Bytes) (ret int64, err error) {
+	defer db.metrics.observe("hclear", time.Now(), &err)
+	return db.clearBatches(DTHASH, name, func(tx *Tx, after Bytes) (int64, Bytes, error) {
+		return tx.hclearBatch(name, after)
+	})
+}
+
+// hclearBatch delete at most loadBatch fields of the hash name after the key after
+func (tx *Tx) hclearBatch(name, after Bytes) (ret int64, last Bytes, err error) {
+	start := encodeTwoKey(DTHASH, name, 0, nil)
+	if after != nil {
+		start = append(after, 0)
+	}
+	it := tx.Iterator(start, encodeTwoKey(DTHASH, name, 1, nil))
+	defer it.Close()
+	for ret < loadBatch && it.Next() {
+		last = it.Key()
+		tx.delete(last)
+		ret++
+	}
+	if ret == 0 {
+		return 0, nil, nil
+	}
+	tx.touch(DTHASH, name)
+	return ret, last, tx.hincrSize(name, -ret)
+}
+
+func (tx *Tx) hincrSize(name Bytes, incr int64) (ret error) {
+	if isize, ierr := tx.Hsize(name); ierr == nil || ierr == leveldb.ErrNotFound {
 		isize += incr
 		skey := encodeHsizeKey(name)
 		if isize == 0 {
-			writer.Delete(skey)
+			tx.delete(skey)
 		} else {
 			buf := NewByInt64(isize)
-			writer.Put(skey, buf)
+			tx.put(skey, buf)
 		}
 		return nil
 	} else {
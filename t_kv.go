@@ -2,6 +2,7 @@ package emssdb
 
 import (
 	"github.com/syndtr/goleveldb/leveldb"
+	"time"
 )
 
 func encodeKvKey(key Bytes) (ret Bytes) {
@@ -13,88 +14,113 @@ func decodeKvKey(slice Bytes) (ret Bytes) {
 }
 
 func (db *DB) MultiSet(keys []Bytes, vals []Bytes) (err error) {
-	writer := db.writer
-	writer.Do()
-	defer writer.Done()
-	// readoption
-	for i := 0; i < len(keys) && i <= len(vals); i++ {
+	defer db.metrics.observe("multiset", time.Now(), &err)
+	return db.updateAll(func(tx *Tx) error {
+		return tx.MultiSet(keys, vals)
+	})
+}
+
+func (db *DB) MultiDelete(keys []Bytes) (err error) {
+	defer db.metrics.observe("multidelete", time.Now(), &err)
+	return db.updateAll(func(tx *Tx) error {
+		return tx.MultiDelete(keys)
+	})
+}
+
+func (db *DB) Set(key Bytes, val Bytes) (err error) {
+	defer db.metrics.observe("set", time.Now(), &err)
+	return db.update(DTKV, key, func(tx *Tx) error {
+		return tx.Set(key, val)
+	})
+}
+
+func (db *DB) Del(key Bytes) (err error) {
+	defer db.metrics.observe("del", time.Now(), &err)
+	return db.update(DTKV, key, func(tx *Tx) error {
+		return tx.Del(key)
+	})
+}
+
+func (db *DB) Incr(key Bytes, by int64) (newval int64, err error) {
+	defer db.metrics.observe("incr", time.Now(), &err)
+	err = db.update(DTKV, key, func(tx *Tx) (err error) {
+		newval, err = tx.Incr(key, by)
+		return err
+	})
+	return newval, err
+}
+
+func (tx *Tx) MultiSet(keys []Bytes, vals []Bytes) (err error) {
+	for i := 0; i < len(keys) && i < len(vals); i++ {
 		rkey := encodeKvKey(keys[i])
-		writer.Put(rkey, vals[i])
+		tx.touch(DTKV, keys[i])
+		tx.put(rkey, vals[i])
 	}
-	return writer.Commit()
+	return nil
 }
 
-func (db *DB) MultiDelete(keys []Bytes) (err error) {
-	writer := db.writer
-	writer.Do()
-	defer writer.Done()
-	// readoption
+func (tx *Tx) MultiDelete(keys []Bytes) (err error) {
 	for _, key := range keys {
 		rkey := encodeKvKey(key)
-		writer.Delete(rkey)
+		tx.touch(DTKV, key)
+		tx.delete(rkey)
 	}
-	return writer.Commit()
+	return nil
 }
 
-func (db *DB) Set(key Bytes, val Bytes) (err error) {
+func (tx *Tx) Set(key Bytes, val Bytes) (err error) {
 	if len(key) == 0 {
 		return ErrEmptyKey
 	}
-	writer := db.writer
-	writer.Do()
-	defer writer.Done()
-	// readoption
+	tx.touch(DTKV, key)
 	rkey := encodeKvKey(key)
-	writer.Put(rkey, val)
-	return writer.Commit()
+	tx.put(rkey, val)
+	return nil
 }
 
-func (db *DB) Del(key Bytes) (err error) {
-	writer := db.writer
-	writer.Do()
-	defer writer.Done()
-	// readoption
+func (tx *Tx) Del(key Bytes) (err error) {
+	tx.touch(DTKV, key)
 	rkey := encodeKvKey(key)
-	writer.Delete(rkey)
-	return writer.Commit()
+	tx.delete(rkey)
+	return nil
 }
 
-func (db *DB) Incr(key Bytes, by int64) (newval int64, err error) {
-	writer := db.writer
-	writer.Do()
-	defer writer.Done()
-	// readoption
-	rkey := encodeKvKey(key)
+func (tx *Tx) Incr(key Bytes, by int64) (newval int64, err error) {
+	tx.touch(DTKV, key)
 	var ival int64
-	if oldvar, oerr := db.db.Get(rkey, nil); oerr == leveldb.ErrNotFound {
+	if oldvar, oerr := tx.Get(key); oerr == leveldb.ErrNotFound {
 		ival = by
-	} else if err == nil {
-		ival = Bytes(oldvar).GetInt64() + by
+	} else if oerr == nil {
+		ival = oldvar.GetInt64() + by
 	} else {
-		return 0, err
+		return 0, oerr
 	}
-	writer.Put(rkey, NewByInt64(ival))
-	return ival, writer.Commit()
+	rkey := encodeKvKey(key)
+	tx.put(rkey, NewByInt64(ival))
+	return ival, nil
 }
 
-func (db *DB) Get(key Bytes) (ret Bytes, err error) {
+func (v *view) Get(key Bytes) (ret Bytes, err error) {
+	defer v.metrics.observe("get", time.Now(), &err)
 	// readoption
 	rkey := encodeKvKey(key)
-	return db.db.Get(rkey, nil)
+	return v.r.Get(rkey, nil)
 }
 
-func (db *DB) Scan(start Bytes, end Bytes) (ret *KIterator) {
+func (v *view) Scan(start Bytes, end Bytes) (ret *KIterator) {
+	defer v.metrics.observe("scan", time.Now(), nil)
 	keyStart, keyEnd := encodeKvKey(start), encodeKvKey(end)
 	if len(end) == 0 {
 		keyEnd = encodeOneKey(DTKV+1, end)
 	}
-	return NewKIterator(db.Iterator(keyStart, keyEnd))
+	return NewKIterator(v.Iterator(keyStart, keyEnd))
 }
 
-func (db *DB) Rscan(start Bytes, end Bytes) (ret *KIterator) {
+func (v *view) Rscan(start Bytes, end Bytes) (ret *KIterator) {
+	defer v.metrics.observe("rscan", time.Now(), nil)
 	keyStart, keyEnd := encodeKvKey(start), encodeKvKey(end)
 	if len(end) == 0 {
 		keyEnd = encodeOneKey(DTKV+1, end)
 	}
-	return NewKIterator(db.RevIterator(keyStart, keyEnd))
+	return NewKIterator(v.RevIterator(keyStart, keyEnd))
 }
@@ -3,6 +3,7 @@ package emssdb
 import (
 	"encoding/binary"
 	"github.com/syndtr/goleveldb/leveldb"
+	"time"
 )
 
 const (
@@ -20,34 +21,36 @@ func decodeQsizeKey(slice Bytes) (ret Bytes) {
 	return decodeOneKey(slice)
 }
 
+// [DTQUEUE][uvarint len(name)][name][0][seq]
 func encodeQitemKey(name Bytes, seq int64) (ret Bytes) {
-	buf := make(Bytes, 1+len(name)+1+8)
-	buf[0] = DTQUEUE
-	copy(buf[1:], name)
-	buf[1+len(name)] = 0
-	binary.BigEndian.PutUint64(buf[2+len(name):], uint64(seq))
-	return buf
+	var bseq [8]byte
+	binary.BigEndian.PutUint64(bseq[:], uint64(seq))
+	return encodeTwoKey(DTQUEUE, name, 0, bseq[:])
 }
 
 func decodeQitemKey(slice Bytes) (name Bytes, seq int64) {
-	if len(slice) < 10 {
+	gname, gseq := decodeTwoKey(slice)
+	if gname == nil || len(gseq) != 8 {
 		return nil, 0
 	}
-	gname := slice[1 : len(slice)-9]
-	gseq := Bytes(slice[len(slice)-8:]).GetInt64()
-	return gname, gseq
+	return gname, Bytes(gseq).GetInt64()
 }
 
-func (db *DB) Qget(name Bytes, seq int64) (ret Bytes, err error) {
+func (v *view) Qget(name Bytes, seq int64) (ret Bytes, err error) {
+	defer v.metrics.observe("qget", time.Now(), &err)
+	return v.qget(name, seq)
+}
+
+func (v *view) qget(name Bytes, seq int64) (ret Bytes, err error) {
 	// readoption
 	rkey := encodeQitemKey(name, seq)
-	return db.db.Get(rkey, nil)
+	return v.r.Get(rkey, nil)
 }
 
-func (db *DB) qgetint64(name Bytes, seq int64) (ret int64, err error) {
+func (v *view) qgetint64(name Bytes, seq int64) (ret int64, err error) {
 	// readoption
 	rkey := encodeQitemKey(name, seq)
-	if val, err := db.db.Get(rkey, nil); err == nil {
+	if val, err := v.r.Get(rkey, nil); err == nil {
 		if len(val) != 8 {
 			return 0, ErrNotIntVal
 		} else {
@@ -59,159 +62,229 @@ func (db *DB) qgetint64(name Bytes, seq int64) (ret int64, err error) {
 	}
 }
 
-func (db *DB) qdelOne(name Bytes, seq int64) (err error) {
+func (tx *Tx) qdelOne(name Bytes, seq int64) (err error) {
 	rkey := encodeQitemKey(name, seq)
-	db.writer.Delete(rkey)
+	tx.delete(rkey)
 	return nil
 }
 
-func (db *DB) qsetOne(name Bytes, seq int64, item Bytes) (err error) {
+func (tx *Tx) qsetOne(name Bytes, seq int64, item Bytes) (err error) {
 	rkey := encodeQitemKey(name, seq)
-	db.writer.Put(rkey, item)
+	tx.put(rkey, item)
 	return nil
 }
 
-func (db *DB) qsetInt(name Bytes, seq int64, item int64) (err error) {
+func (tx *Tx) qsetInt(name Bytes, seq int64, item int64) (err error) {
 	rkey := encodeQitemKey(name, seq)
-	db.writer.Put(rkey, NewByInt64(item))
+	tx.put(rkey, NewByInt64(item))
 	return nil
 }
 
-func (db *DB) qsetSize(name Bytes, isize int64) (err error) {
-	writer := db.writer
+func (tx *Tx) qsetSize(name Bytes, isize int64) (err error) {
 	skey := encodeQsizeKey(name)
 	if isize == 0 {
-		writer.Delete(skey)
+		tx.delete(skey)
 	} else {
 		buf := NewByInt64(isize)
-		writer.Put(skey, buf)
+		tx.put(skey, buf)
 	}
 	return nil
 }
 
-func (db *DB) Qsize(name Bytes) (ret int64, err error) {
+func (v *view) Qsize(name Bytes) (ret int64, err error) {
+	defer v.metrics.observe("qsize", time.Now(), &err)
 	skey := encodeQsizeKey(name)
 	// readoption
 	isize := int64(0)
-	ssize, err := db.db.Get(skey, nil)
+	ssize, err := v.r.Get(skey, nil)
 	if err == nil {
 		isize = Bytes(ssize).GetInt64()
 	}
 	return isize, err
 }
 
-func (db *DB) Qfront(name Bytes) (ret Bytes, err error) {
-	if seq, serr := db.qgetint64(name, qFRONT_SEQ); serr == nil {
-		return db.Qget(name, seq)
+func (v *view) Qfront(name Bytes) (ret Bytes, err error) {
+	defer v.metrics.observe("qfront", time.Now(), &err)
+	if seq, serr := v.qgetint64(name, qFRONT_SEQ); serr == nil {
+		return v.qget(name, seq)
 	} else {
 		return nil, serr
 	}
 }
 
-func (db *DB) Qback(name Bytes) (ret Bytes, err error) {
-	if seq, serr := db.qgetint64(name, qBACK_SEQ); serr == nil {
-		return db.Qget(name, seq)
+func (v *view) Qback(name Bytes) (ret Bytes, err error) {
+	defer v.metrics.observe("qback", time.Now(), &err)
+	if seq, serr := v.qgetint64(name, qBACK_SEQ); serr == nil {
+		return v.qget(name, seq)
 	} else {
 		return nil, serr
 	}
 }
 
-func (db *DB) _qpush(name, item Bytes, fbseq int64) (ret error) {
-	writer := db.writer
-	writer.Do()
-	defer writer.Done()
-
-	isize, ierr := db.Qsize(name)
+func (tx *Tx) _qpush(name, item Bytes, fbseq int64) (ret error) {
+	if len(name) > tx.maxKeyLen {
+		return ErrLongKey
+	}
+	tx.touch(DTQUEUE, name)
+	isize, ierr := tx.Qsize(name)
 	if ierr != nil && ierr != leveldb.ErrNotFound {
 		return ierr
 	}
 	if isize >= qBITMOD { //  isize+1 >= qMAX_SIZE {
 		return ErrOutOfRange
 	}
-	seq, serr := db.qgetint64(name, fbseq)
+	seq, serr := tx.qgetint64(name, fbseq)
 	// update front and/or back
 	if serr == leveldb.ErrNotFound {
 		seq = qITEM_SEQ_INIT
-		db.qsetInt(name, qFRONT_SEQ, seq)
-		db.qsetInt(name, qBACK_SEQ, seq)
+		tx.qsetInt(name, qFRONT_SEQ, seq)
+		tx.qsetInt(name, qBACK_SEQ, seq)
 	} else if serr == nil {
 		if fbseq == qFRONT_SEQ {
 			seq = (seq + 1) & qBITMOD
 		} else {
 			seq = (seq - 1) & qBITMOD
 		}
-		db.qsetInt(name, fbseq, seq)
+		tx.qsetInt(name, fbseq, seq)
 	} else {
 		return serr
 	}
 
 	// insert item
-	db.qsetOne(name, seq, item)
+	tx.qsetOne(name, seq, item)
 	// change queue size
-	db.qsetSize(name, isize+1)
-	return writer.Commit()
+	tx.qsetSize(name, isize+1)
+	return nil
 }
 
 func (db *DB) QpushFront(name, item Bytes) (ret error) {
-	return db._qpush(name, item, qFRONT_SEQ)
+	defer db.metrics.observe("qpushfront", time.Now(), &ret)
+	return db.update(DTQUEUE, name, func(tx *Tx) error {
+		return tx.QpushFront(name, item)
+	})
 }
 
 func (db *DB) QpushBack(name, item Bytes) (ret error) {
-	return db._qpush(name, item, qBACK_SEQ)
+	defer db.metrics.observe("qpushback", time.Now(), &ret)
+	return db.update(DTQUEUE, name, func(tx *Tx) error {
+		return tx.QpushBack(name, item)
+	})
+}
+
+func (tx *Tx) QpushFront(name, item Bytes) (ret error) {
+	return tx._qpush(name, item, qFRONT_SEQ)
 }
 
-func (db *DB) _qpop(name Bytes, fbseq int64) (item Bytes, ret error) {
-	writer := db.writer
-	writer.Do()
-	defer writer.Done()
+func (tx *Tx) QpushBack(name, item Bytes) (ret error) {
+	return tx._qpush(name, item, qBACK_SEQ)
+}
 
-	isize, ierr := db.Qsize(name)
+func (tx *Tx) _qpop(name Bytes, fbseq int64) (item Bytes, ret error) {
+	tx.touch(DTQUEUE, name)
+	isize, ierr := tx.Qsize(name)
 	if ierr != nil && ierr != leveldb.ErrNotFound {
 		return nil, ierr
 	}
 	//if isize < 1 { return ErrOutOfRange }
-	seq, serr := db.qgetint64(name, fbseq)
+	seq, serr := tx.qgetint64(name, fbseq)
 	if serr != nil {
 		return nil, serr
 	}
 
-	gitem, gerr := db.Qget(name, seq)
+	gitem, gerr := tx.Qget(name, seq)
 	if gerr != nil {
 		return gitem, gerr
 	}
 
-	db.qdelOne(name, seq)
+	tx.qdelOne(name, seq)
 	isize--
 	if isize <= 0 {
-		db.qdelOne(name, qFRONT_SEQ)
-		db.qdelOne(name, qBACK_SEQ)
+		tx.qdelOne(name, qFRONT_SEQ)
+		tx.qdelOne(name, qBACK_SEQ)
 	} else {
 		if fbseq == qFRONT_SEQ {
 			seq = (seq - 1) & qBITMOD
 		} else {
 			seq = (seq + 1) & qBITMOD
 		}
-		db.qsetInt(name, fbseq, seq)
+		tx.qsetInt(name, fbseq, seq)
 	}
-	db.qsetSize(name, isize)
+	tx.qsetSize(name, isize)
 
-	return gitem, writer.Commit()
+	return gitem, nil
 }
 
 func (db *DB) QpopFront(name Bytes) (item Bytes, ret error) {
-	return db._qpop(name, qFRONT_SEQ)
+	defer db.metrics.observe("qpopfront", time.Now(), &ret)
+	ret = db.update(DTQUEUE, name, func(tx *Tx) (err error) {
+		item, err = tx.QpopFront(name)
+		return err
+	})
+	return item, ret
 }
 
 func (db *DB) QpopBack(name Bytes) (item Bytes, ret error) {
-	return db._qpop(name, qBACK_SEQ)
+	defer db.metrics.observe("qpopback", time.Now(), &ret)
+	ret = db.update(DTQUEUE, name, func(tx *Tx) (err error) {
+		item, err = tx.QpopBack(name)
+		return err
+	})
+	return item, ret
+}
+
+func (tx *Tx) QpopFront(name Bytes) (item Bytes, ret error) {
+	return tx._qpop(name, qFRONT_SEQ)
+}
+
+func (tx *Tx) QpopBack(name Bytes) (item Bytes, ret error) {
+	return tx._qpop(name, qBACK_SEQ)
+}
+
+// Qclear delete all the items of the queue name from the front in batches,
+// and return the number of them
+func (db *DB) Qclear(name Bytes) (ret int64, err error) {
+	defer db.metrics.observe("qclear", time.Now(), &err)
+	// the batches delete the items by their seqs from the front pointer, they need no key to start after
+	return db.clearBatches(DTQUEUE, name, func(tx *Tx, after Bytes) (n int64, last Bytes, err error) {
+		n, err = tx.qclearBatch(name)
+		return n, nil, err
+	})
+}
+
+func (tx *Tx) qclearBatch(name Bytes) (ret int64, err error) {
+	size, err := tx.Qsize(name)
+	if err == leveldb.ErrNotFound {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	seq, err := tx.qgetint64(name, qFRONT_SEQ)
+	if err != nil {
+		return 0, err
+	}
+	tx.touch(DTQUEUE, name)
+	for ret < loadBatch && ret < size {
+		tx.qdelOne(name, seq)
+		seq = (seq - 1) & qBITMOD
+		ret++
+	}
+	if size -= ret; size <= 0 {
+		tx.qdelOne(name, qFRONT_SEQ)
+		tx.qdelOne(name, qBACK_SEQ)
+	} else {
+		tx.qsetInt(name, qFRONT_SEQ, seq)
+	}
+	return ret, tx.qsetSize(name, size)
 }
 
-func (db *DB) Qlist(sname, ename Bytes) (ret []Bytes) {
+func (v *view) Qlist(sname, ename Bytes) (ret []Bytes) {
+	defer v.metrics.observe("qlist", time.Now(), nil)
 	start, end := encodeQsizeKey(sname), encodeQsizeKey(ename)
 	if len(ename) == 0 {
 		end = encodeOneKey(DTQSIZE+1, nil)
 	}
-	it := db.Iterator(start, end)
+	it := v.Iterator(start, end)
+	defer it.Close()
 	var list = make([]Bytes, 0)
 	for it.Next() {
 		ks := it.Key()
@@ -222,15 +295,12 @@ func (db *DB) Qlist(sname, ename Bytes) (ret []Bytes) {
 }
 
 func encodeQitemiteraKey(name Bytes, fill byte) (ret Bytes) {
-	buf := make(Bytes, 1+len(name)+1)
-	buf[0] = DTQUEUE
-	copy(buf[1:], name)
-	buf[1+len(name)] = fill
-	return buf
+	return encodeTwoKey(DTQUEUE, name, fill, nil)
 }
 
-func (db *DB) Qscan(name Bytes) (ret *QIterator) {
+func (v *view) Qscan(name Bytes) (ret *QIterator) {
+	defer v.metrics.observe("qscan", time.Now(), nil)
 	//key_start, key_end := encodeQitemiteraKey(name, 0), encodeQitemiteraKey(name, 1)
 	keyStart, keyEnd := encodeQitemKey(name, 0), encodeQitemKey(name, 0x7FFFFFFFffffffff)
-	return NewQIterator(db.Iterator(keyStart, keyEnd))
+	return NewQIterator(v.Iterator(keyStart, keyEnd))
 }
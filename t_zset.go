@@ -3,6 +3,7 @@ package emssdb
 import (
 	"encoding/binary"
 	"github.com/syndtr/goleveldb/leveldb"
+	"time"
 )
 
 const (
@@ -27,7 +28,7 @@ func decodeZsizeKey(slice Bytes) (ret Bytes) {
 	return decodeOneKey(slice)
 }
 
-// [DTZSET][len(name)][name][0][key]
+// [DTZSET][uvarint len(name)][name][0][key]
 func encodeZsetKey(name Bytes, key Bytes) (ret Bytes) {
 	return encodeTwoKey(DTZSET, name, 0, key)
 }
@@ -36,109 +37,127 @@ func decodeZsetKey(slice Bytes) (name, key Bytes) {
 	return decodeTwoKey(slice)
 }
 
-// [DTZSCORE][len(name)][name][0][score][key]
+// [DTZSCORE][uvarint len(name)][name][0][score][key]
 func encodeZscoreKey(name Bytes, key Bytes, score int64) (ret Bytes) {
-	length := 1 + 1 + len(name) + 1 + 8 + len(key)
-	buf := make(Bytes, length)
-	buf[0] = DTZSCORE
-	buf[1] = byte(len(name))
-	p := buf[2:]
-	copy(p, name)
-	p = p[len(name):]
-	binary.BigEndian.PutUint64(p[1:], enInt(score))
-	p = p[9:]
-	copy(p, key)
-	return buf
+	p := make(Bytes, 8+len(key))
+	binary.BigEndian.PutUint64(p, enInt(score))
+	copy(p[8:], key)
+	return encodeTwoKey(DTZSCORE, name, 0, p)
 }
 
+// decodeZscoreKey return a nil name for an undecodable key
 func decodeZscoreKey(slice Bytes) (name Bytes, key Bytes, score int64) {
-	p := slice[2:]
-	gname := p[:slice[1]]
-	p = p[len(gname)+1:]
+	gname, p := decodeTwoKey(slice)
+	if gname == nil || len(p) < 8 {
+		return nil, nil, 0
+	}
 	gscore := deInt(Bytes(p[:8]).GetUInt64())
 	gkey := p[8:]
 	return gname, gkey, gscore
 }
 
-func (db *DB) Zget(name, key Bytes) (score int64, err error) {
+func (v *view) Zget(name, key Bytes) (score int64, err error) {
+	defer v.metrics.observe("zget", time.Now(), &err)
 	// readoption
 	rkey := encodeZsetKey(name, key)
-	val, verr := db.db.Get(rkey, nil)
+	val, verr := v.r.Get(rkey, nil)
 	return Bytes(val).GetInt64(), verr
 }
 
 func (db *DB) Zset(name, key Bytes, score int64) (err error) {
-	writer := db.writer
-	writer.Do()
-	defer writer.Done()
-	// readoption
-	if st := db.zsetOne(name, key, score); st == StatSucChange {
-		if err := db.zincrSize(name, 1); err != nil {
+	defer db.metrics.observe("zset", time.Now(), &err)
+	return db.update(DTZSET, name, func(tx *Tx) error {
+		return tx.Zset(name, key, score)
+	})
+}
+
+func (db *DB) Zdel(name, key Bytes) (err error) {
+	defer db.metrics.observe("zdel", time.Now(), &err)
+	return db.update(DTZSET, name, func(tx *Tx) error {
+		return tx.Zdel(name, key)
+	})
+}
+
+func (db *DB) Zincr(name Bytes, key Bytes, by int64) (newval int64, err error) {
+	defer db.metrics.observe("zincr", time.Now(), &err)
+	err = db.update(DTZSET, name, func(tx *Tx) (err error) {
+		newval, err = tx.Zincr(name, key, by)
+		return err
+	})
+	return newval, err
+}
+
+func (tx *Tx) Zset(name, key Bytes, score int64) (err error) {
+	tx.touch(DTZSET, name)
+	if st := tx.zsetOne(name, key, score); st == StatSucChange {
+		if err := tx.zincrSize(name, 1); err != nil {
 			return err
 		}
 	} else if st == StatSuccess {
 	} else {
 		return st
 	}
-	return writer.Commit()
+	return nil
 }
 
-func (db *DB) Zdel(name, key Bytes) (err error) {
-	writer := db.writer
-	writer.Do()
-	defer writer.Done()
-	// readoption
-	if st := db.zdelOne(name, key); st == StatSucChange {
-		db.zincrSize(name, -1)
+func (tx *Tx) Zdel(name, key Bytes) (err error) {
+	tx.touch(DTZSET, name)
+	if st := tx.zdelOne(name, key); st == StatSucChange {
+		return tx.zincrSize(name, -1)
+	} else if st != StatNotFound {
+		return st
 	}
-	return writer.Commit()
+	return nil
 }
 
-func (db *DB) Zincr(name Bytes, key Bytes, by int64) (newval int64, err error) {
-	writer := db.writer
-	writer.Do()
-	defer writer.Done()
-	// readoption
+func (tx *Tx) Zincr(name Bytes, key Bytes, by int64) (newval int64, err error) {
+	tx.touch(DTZSET, name)
 	var ival int64
-	if oldvar, oerr := db.Zget(name, key); oerr == leveldb.ErrNotFound {
+	if oldvar, oerr := tx.Zget(name, key); oerr == leveldb.ErrNotFound {
 		ival = by
-		db.hincrSize(name, 1)
 	} else if oerr == nil {
 		ival = oldvar + by
 	} else {
 		return 0, oerr
 	}
 
-	if st := db.zsetOne(name, key, ival); st == StatSuccess || st == StatSucChange {
-		return ival, writer.Commit()
+	if st := tx.zsetOne(name, key, ival); st == StatSucChange {
+		return ival, tx.zincrSize(name, 1)
+	} else if st == StatSuccess {
+		return ival, nil
 	} else {
 		return ival, st
 	}
 }
 
-func (db *DB) Zsize(name Bytes) (ret int64, err error) {
+func (v *view) Zsize(name Bytes) (ret int64, err error) {
+	defer v.metrics.observe("zsize", time.Now(), &err)
 	skey := encodeZsizeKey(name)
 	// readoption
-	ssize, err := db.db.Get(skey, nil)
+	ssize, err := v.r.Get(skey, nil)
 	return Bytes(ssize).GetInt64(), err
 }
 
-func (db *DB) Zscan(name Bytes, start, end int64) (ret *ZIterator) {
+func (v *view) Zscan(name Bytes, start, end int64) (ret *ZIterator) {
+	defer v.metrics.observe("zscan", time.Now(), nil)
 	keyStart, keyEnd := encodeZscoreKey(name, nil, start), encodeZscoreKey(name, nil, end)
-	return NewZIterator(db.Iterator(keyStart, keyEnd))
+	return NewZIterator(v.Iterator(keyStart, keyEnd))
 }
 
-func (db *DB) Zrscan(name Bytes, start, end int64) (ret *ZIterator) {
+func (v *view) Zrscan(name Bytes, start, end int64) (ret *ZIterator) {
+	defer v.metrics.observe("zrscan", time.Now(), nil)
 	keyStart, keyEnd := encodeZscoreKey(name, nil, start), encodeZscoreKey(name, nil, end)
-	return NewZIterator(db.RevIterator(keyStart, keyEnd))
+	return NewZIterator(v.RevIterator(keyStart, keyEnd))
 }
 
-func (db *DB) Zlist(sname, ename Bytes) (ret []Bytes) {
+func (v *view) Zlist(sname, ename Bytes) (ret []Bytes) {
+	defer v.metrics.observe("zlist", time.Now(), nil)
 	start, end := encodeZsizeKey(sname), encodeZsizeKey(ename)
 	if len(ename) == 0 {
 		end = encodeOneKey(DTZSIZE+1, nil)
 	}
-	it := db.Iterator(start, end)
+	it := v.Iterator(start, end)
+	defer it.Close()
 	var list = make([]Bytes, 0)
 	for it.Next() {
 		ks := it.Key()
@@ -148,46 +167,75 @@ func (db *DB) Zlist(sname, ename Bytes) (ret []Bytes) {
 	return list
 }
 
-func (db *DB) zsetOne(name, key Bytes, score int64) (ret Status) {
-	if verr := isVaildHashKey(name, key); verr != nil {
+func (tx *Tx) zsetOne(name, key Bytes, score int64) (ret Status) {
+	if verr := tx.isVaildNewHashKey(name, key); verr != nil {
 		return verr
 	}
-	writer := db.writer
-	gosc, zgerr := db.Zget(name, key)
+	gosc, zgerr := tx.Zget(name, key)
 	if zgerr == nil {
-		writer.Delete(encodeZscoreKey(name, key, gosc))
+		tx.delete(encodeZscoreKey(name, key, gosc))
 		ret = StatSuccess
 	} else {
 		ret = StatSucChange
 	}
-	writer.Put(encodeZscoreKey(name, key, score), nil)
-	writer.Put(encodeZsetKey(name, key), NewByInt64(score))
+	tx.put(encodeZscoreKey(name, key, score), nil)
+	tx.put(encodeZsetKey(name, key), NewByInt64(score))
 	return
 }
 
-func (db *DB) zdelOne(name, key Bytes) (ret Status) {
+func (tx *Tx) zdelOne(name, key Bytes) (ret Status) {
 	if verr := isVaildHashKey(name, key); verr != nil {
 		return verr
 	}
-	writer := db.writer
-	if gosc, zgerr := db.Zget(name, key); zgerr == nil {
-		writer.Delete(encodeZsetKey(name, key))
-		writer.Delete(encodeZscoreKey(name, key, gosc))
+	if gosc, zgerr := tx.Zget(name, key); zgerr == nil {
+		tx.delete(encodeZsetKey(name, key))
+		tx.delete(encodeZscoreKey(name, key, gosc))
 		return StatSucChange
 	} else {
 		return StatNotFound
 	}
 }
 
-func (db *DB) zincrSize(name Bytes, incr int64) (ret error) {
-	writer := db.writer
-	if isize, ierr := db.Zsize(name); ierr == nil || ierr == leveldb.ErrNotFound {
+// Zclear delete all the members of the zset name with their scores in batches,
+// and return the number of them.
+// The members set meanwhile before the ones already deleted are kept.
+func (db *DB) Zclear(name Bytes) (ret int64, err error) {
+	defer db.metrics.observe("zclear", time.Now(), &err)
+	return db.clearBatches(DTZSET, name, func(tx *Tx, after Bytes) (int64, Bytes, error) {
+		return tx.zclearBatch(name, after)
+	})
+}
+
+// zclearBatch delete at most loadBatch members of the zset name after the member key after
+func (tx *Tx) zclearBatch(name, after Bytes) (ret int64, last Bytes, err error) {
+	start := encodeTwoKey(DTZSET, name, 0, nil)
+	if after != nil {
+		start = append(after, 0)
+	}
+	it := tx.Iterator(start, encodeTwoKey(DTZSET, name, 1, nil))
+	defer it.Close()
+	for ret < loadBatch && it.Next() {
+		last = it.Key()
+		_, key := decodeZsetKey(last)
+		tx.delete(last)
+		tx.delete(encodeZscoreKey(name, key, it.Value().GetInt64()))
+		ret++
+	}
+	if ret == 0 {
+		return 0, nil, nil
+	}
+	tx.touch(DTZSET, name)
+	return ret, last, tx.zincrSize(name, -ret)
+}
+
+func (tx *Tx) zincrSize(name Bytes, incr int64) (ret error) {
+	if isize, ierr := tx.Zsize(name); ierr == nil || ierr == leveldb.ErrNotFound {
 		isize += incr
 		skey := encodeZsizeKey(name)
 		if isize == 0 {
-			writer.Delete(skey)
+			tx.delete(skey)
 		} else {
-			writer.Put(skey, NewByInt64(isize))
+			tx.put(skey, NewByInt64(isize))
 		}
 		return nil
 	} else {
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/neverlee/emssdb"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	dbPath     = flag.String("path", "./bench_data", "database directory, removed before each run")
+	writers    = flag.Int("writers", 32, "concurrent writers")
+	ops        = flag.Int("ops", 2000, "operations of each writer")
+	syncWrites = flag.Bool("sync", false, "fsync every commit")
+)
+
+// run writes Set/Hset/Zset/QpushBack from the concurrent writers and returns the ops per second
+func run(group bool) (ret float64) {
+	os.RemoveAll(*dbPath)
+	defer os.RemoveAll(*dbPath)
+	opt := emssdb.Options{
+		Path:        *dbPath,
+		CacheSize:   8,
+		Compression: true,
+		GroupCommit: group,
+	}
+	if *syncWrites {
+		opt.Sync = emssdb.SyncEveryWrite
+	}
+	db, err := emssdb.OpenDB(opt)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
+	}
+	defer db.Close()
+
+	var wait sync.WaitGroup
+	start := time.Now()
+	for w := 0; w < *writers; w++ {
+		wait.Add(1)
+		go func(w int) {
+			defer wait.Done()
+			name := emssdb.Bytes("bench" + strconv.Itoa(w))
+			for i := 0; i < *ops; i++ {
+				key := emssdb.Bytes(strconv.Itoa(i))
+				switch i % 4 {
+				case 0:
+					db.Set(append(name, key...), key)
+				case 1:
+					db.Hset(name, key, key)
+				case 2:
+					db.Zset(name, key, int64(i))
+				case 3:
+					db.QpushBack(name, key)
+				}
+			}
+		}(w)
+	}
+	wait.Wait()
+	return float64(*writers**ops) / time.Since(start).Seconds()
+}
+
+func main() {
+	flag.Parse()
+	fmt.Printf("writers %d, ops %d, sync %v\n", *writers, *ops, *syncWrites)
+	plain := run(false)
+	fmt.Printf("commit one by one: %10.0f ops/s\n", plain)
+	group := run(true)
+	fmt.Printf("group commit:      %10.0f ops/s (%.2fx)\n", group, group/plain)
+}
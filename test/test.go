@@ -20,7 +20,7 @@ func main() {
 		fmt.Printf("Usage: %s dbpath\n", os.Args[0])
 		return
 	}
-	opt := emssdb.Options{os.Args[1], 4, true}
+	opt := emssdb.Options{Path: os.Args[1], CacheSize: 4, Compression: true}
 	db, err := emssdb.OpenDB(opt)
 	if err != nil {
 		fmt.Println(err)
@@ -0,0 +1,324 @@
+package emssdb
+
+import (
+	"bytes"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/iterator"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// Tx is a write transaction over all the data types.
+// The reads of a Tx, the point reads and the scans, see its own writes.
+// An iterator sees the writes made before it is created.
+type Tx struct {
+	view
+	db      *DB
+	batch   leveldb.Batch
+	writes  map[string]Bytes
+	touched map[string]bool
+}
+
+// txReader look up the pending writes of a Tx before the base reader
+type txReader struct {
+	base   reader
+	writes map[string]Bytes // nil value means deleted
+}
+
+func (r *txReader) Get(key []byte, ro *opt.ReadOptions) (value []byte, err error) {
+	if val, ok := r.writes[string(key)]; ok {
+		if val == nil {
+			return nil, leveldb.ErrNotFound
+		}
+		return NewByClone(val), nil
+	}
+	return r.base.Get(key, ro)
+}
+
+func (r *txReader) NewIterator(slice *util.Range, ro *opt.ReadOptions) iterator.Iterator {
+	it := &txIterator{base: r.base.NewIterator(slice, ro)}
+	for key := range r.writes {
+		k := Bytes(key)
+		if slice != nil && ((slice.Start != nil && bytes.Compare(k, slice.Start) < 0) || (slice.Limit != nil && bytes.Compare(k, slice.Limit) >= 0)) {
+			continue
+		}
+		it.keys = append(it.keys, k)
+	}
+	sort.Slice(it.keys, func(i, j int) bool { return bytes.Compare(it.keys[i], it.keys[j]) < 0 })
+	it.vals = make([]Bytes, len(it.keys))
+	for i, k := range it.keys {
+		it.vals[i] = r.writes[string(k)]
+	}
+	return it
+}
+
+const (
+	txItUnset = iota // not positioned yet
+	txItValid
+	txItEnd   // after the last key
+	txItStart // before the first key
+)
+
+// txIterator merge the sorted pending writes of a Tx, taken at its creation,
+// into the iterator of the committed data: a write hides the committed key,
+// a deleted one (nil value) is skipped.
+type txIterator struct {
+	base     iterator.Iterator
+	keys     []Bytes
+	vals     []Bytes
+	i        int  // the current pending write, or the next one in the direction
+	backward bool // the direction of the last move
+	fromTx   bool // the current key is the pending write i
+	pos      int
+}
+
+// settle move to the first key in the direction from the current positions of
+// the committed data and of the writes
+func (it *txIterator) settle() bool {
+	for {
+		bvalid := it.base.Valid()
+		wvalid := it.i >= 0 && it.i < len(it.keys)
+		if !bvalid && !wvalid {
+			if it.backward {
+				it.pos = txItStart
+			} else {
+				it.pos = txItEnd
+			}
+			return false
+		}
+		c := -1 // the committed key comes first in the direction
+		if !bvalid {
+			c = 1
+		} else if wvalid {
+			if c = bytes.Compare(it.base.Key(), it.keys[it.i]); it.backward {
+				c = -c
+			}
+		}
+		switch {
+		case c < 0:
+			it.fromTx, it.pos = false, txItValid
+			return true
+		case c == 0:
+			// hidden by the pending write
+			it.baseStep()
+		case it.vals[it.i] == nil:
+			it.writeStep()
+		default:
+			it.fromTx, it.pos = true, txItValid
+			return true
+		}
+	}
+}
+
+func (it *txIterator) baseStep() {
+	if it.backward {
+		it.base.Prev()
+	} else {
+		it.base.Next()
+	}
+}
+
+func (it *txIterator) writeStep() {
+	if it.backward {
+		it.i--
+	} else {
+		it.i++
+	}
+}
+
+// search return the index of the first pending write not below key
+func (it *txIterator) search(key []byte) (ret int) {
+	return sort.Search(len(it.keys), func(i int) bool { return bytes.Compare(it.keys[i], key) >= 0 })
+}
+
+func (it *txIterator) First() bool {
+	it.backward, it.i = false, 0
+	it.base.First()
+	return it.settle()
+}
+
+func (it *txIterator) Last() bool {
+	it.backward, it.i = true, len(it.keys)-1
+	it.base.Last()
+	return it.settle()
+}
+
+func (it *txIterator) Seek(key []byte) bool {
+	it.backward, it.i = false, it.search(key)
+	it.base.Seek(key)
+	return it.settle()
+}
+
+func (it *txIterator) Next() bool {
+	switch it.pos {
+	case txItUnset, txItStart:
+		return it.First()
+	case txItEnd:
+		return false
+	}
+	if it.backward {
+		// turn around: the first key after the current one
+		key := NewByClone(it.Key())
+		it.backward, it.i = false, it.search(key)
+		if it.base.Seek(key) && bytes.Equal(it.base.Key(), key) {
+			it.base.Next()
+		}
+		if it.i < len(it.keys) && bytes.Equal(it.keys[it.i], key) {
+			it.i++
+		}
+	} else if it.fromTx {
+		it.i++
+	} else {
+		it.base.Next()
+	}
+	return it.settle()
+}
+
+func (it *txIterator) Prev() bool {
+	switch it.pos {
+	case txItUnset, txItEnd:
+		return it.Last()
+	case txItStart:
+		return false
+	}
+	if !it.backward {
+		// turn around: the last key before the current one
+		key := NewByClone(it.Key())
+		it.backward, it.i = true, it.search(key)-1
+		if it.base.Seek(key) {
+			it.base.Prev()
+		} else {
+			it.base.Last()
+		}
+	} else if it.fromTx {
+		it.i--
+	} else {
+		it.base.Prev()
+	}
+	return it.settle()
+}
+
+func (it *txIterator) Valid() bool {
+	return it.pos == txItValid
+}
+
+func (it *txIterator) Key() []byte {
+	if it.pos != txItValid {
+		return nil
+	}
+	if it.fromTx {
+		return it.keys[it.i]
+	}
+	return it.base.Key()
+}
+
+func (it *txIterator) Value() []byte {
+	if it.pos != txItValid {
+		return nil
+	}
+	if it.fromTx {
+		return it.vals[it.i]
+	}
+	return it.base.Value()
+}
+
+func (it *txIterator) Release() {
+	it.base.Release()
+}
+
+func (it *txIterator) SetReleaser(releaser util.Releaser) {
+	it.base.SetReleaser(releaser)
+}
+
+func (it *txIterator) Error() error {
+	return it.base.Error()
+}
+
+func newTx(d *DB) (tx *Tx) {
+	tx = &Tx{db: d}
+	tx.writes = make(map[string]Bytes)
+	tx.touched = make(map[string]bool)
+	// the reads of a Tx are not counted
+	tx.r = &txReader{base: d.prefixed(d.db), writes: tx.writes}
+	tx.maxKeyLen = d.maxKeyLen
+	return tx
+}
+
+// Update run fn in a transaction. All the writes of fn are committed as one batch
+// if it returns nil, or are dropped if it returns an error.
+// Update excludes all the other writers while fn runs.
+// The Tx must not be used after fn returns.
+func (d *DB) Update(fn func(tx *Tx) error) (err error) {
+	defer d.metrics.observe("update", time.Now(), &err)
+	return d.updateAll(fn)
+}
+
+// updateAll is Update for the internal use, it is not measured by the metrics
+func (d *DB) updateAll(fn func(tx *Tx) error) (err error) {
+	d.writer.LockAll()
+	defer d.writer.UnlockAll()
+	return d.commit(fn)
+}
+
+// update is Update for fn which only writes the container dt/name,
+// it runs concurrently with the writers of other containers
+func (d *DB) update(dt byte, name Bytes, fn func(tx *Tx) error) (err error) {
+	d.writer.Lock(dt, name)
+	defer d.writer.Unlock(dt, name)
+	return d.commit(fn)
+}
+
+// clearBatches run batch in the transactions of the container dt/name until it
+// removes less than loadBatch items, so the writers of the container are not
+// blocked for long, and return the number of the items removed.
+// batch returns the last key it deleted, the next batch starts after it
+// instead of stepping over the deletions of the batches before.
+func (d *DB) clearBatches(dt byte, name Bytes, batch func(tx *Tx, after Bytes) (n int64, last Bytes, err error)) (ret int64, err error) {
+	var after Bytes
+	for {
+		var n int64
+		err = d.update(dt, name, func(tx *Tx) (err error) {
+			n, after, err = batch(tx, after)
+			return err
+		})
+		if err != nil {
+			return ret, err
+		}
+		if ret += n; n < loadBatch {
+			return ret, nil
+		}
+	}
+}
+
+func (d *DB) commit(fn func(tx *Tx) error) (err error) {
+	if d.readOnly {
+		return ErrReadOnly
+	}
+	tx := newTx(d)
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if err = tx.bumpVersions(); err != nil {
+		return err
+	}
+	if err = d.writer.Commit(&tx.batch); err == nil {
+		atomic.AddInt64(&d.counters.writes, 1)
+	}
+	return err
+}
+
+func (tx *Tx) put(key, val Bytes) {
+	if val == nil {
+		val = Bytes{}
+	}
+	tx.writes[string(key)] = NewByClone(val)
+	tx.batch.Put(tx.db.rawKey(key), val)
+}
+
+func (tx *Tx) delete(key Bytes) {
+	tx.writes[string(key)] = nil
+	tx.batch.Delete(tx.db.rawKey(key))
+}
@@ -0,0 +1,135 @@
+package emssdb
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+func TestTxScanOwnWrites(t *testing.T) {
+	d := openTestDB(t, Options{})
+	ns, _ := d.Namespace("ns")
+	for _, h := range []*DB{d, ns} {
+		for _, k := range []string{"a", "c", "e"} {
+			h.Set(Bytes(k), Bytes("old"))
+			h.Hset(Bytes("h"), Bytes(k), Bytes("old"))
+		}
+		err := h.Update(func(tx *Tx) error {
+			tx.Set(Bytes("b"), Bytes("new"))
+			tx.Del(Bytes("c"))
+			tx.Set(Bytes("e"), Bytes("new"))
+			tx.Hset(Bytes("h"), Bytes("d"), Bytes("new"))
+			tx.Hdel(Bytes("h"), Bytes("a"))
+
+			var got []string
+			it := tx.Scan(nil, nil)
+			for it.Next() {
+				got = append(got, string(it.Key())+"="+string(it.Value()))
+			}
+			it.Close()
+			if fmt.Sprint(got) != "[a=old b=new e=new]" {
+				t.Error("scan", got)
+			}
+			got = nil
+			rit := tx.Rscan(nil, nil)
+			for rit.Next() {
+				got = append(got, string(rit.Key()))
+			}
+			rit.Close()
+			if fmt.Sprint(got) != "[e b a]" {
+				t.Error("rscan", got)
+			}
+			got = nil
+			hit := tx.Hscan(Bytes("h"), nil, nil)
+			for hit.Next() {
+				got = append(got, string(hit.Key())+"="+string(hit.Value()))
+			}
+			hit.Close()
+			if fmt.Sprint(got) != "[c=old d=new e=old]" {
+				t.Error("hscan", got)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkClean(t, d)
+}
+
+// TestTxIteratorModel move the merged iterator of a Tx at random, in both directions,
+// and compare it with the sorted keys it should see
+func TestTxIteratorModel(t *testing.T) {
+	d := openTestDB(t, Options{})
+	rnd := rand.New(rand.NewSource(1))
+	key := func() Bytes { return Bytes(fmt.Sprintf("k%02d", rnd.Intn(40))) }
+	for i := 0; i < 20; i++ {
+		d.RawSet(key(), Bytes("c"))
+	}
+	for round := 0; round < 50; round++ {
+		d.Update(func(tx *Tx) error {
+			model := map[string]string{}
+			it := d.db.NewIterator(nil, nil)
+			for it.Next() {
+				if it.Key()[0] == 'k' {
+					model[string(it.Key())] = string(it.Value())
+				}
+			}
+			it.Release()
+			for i := 0; i < 15; i++ {
+				k := key()
+				if rnd.Intn(2) == 0 {
+					tx.delete(k)
+					delete(model, string(k))
+				} else {
+					tx.put(k, Bytes(fmt.Sprint("w", round)))
+					model[string(k)] = fmt.Sprint("w", round)
+				}
+			}
+			var keys []string
+			for k := range model {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+
+			mit := tx.r.NewIterator(&util.Range{Start: Bytes("k"), Limit: Bytes("l")}, nil)
+			defer mit.Release()
+			pos := -1 // the index in keys, -1 before the first, len(keys) after the last
+			for step := 0; step < 60; step++ {
+				var ok bool
+				switch op := rnd.Intn(10); {
+				case op < 4:
+					ok = mit.Next()
+					if pos < len(keys) {
+						pos++
+					}
+				case op < 8:
+					ok = mit.Prev()
+					if pos == len(keys) || pos == -1 && step == 0 {
+						pos = len(keys) - 1
+					} else if pos >= 0 {
+						pos--
+					}
+				case op == 8:
+					seek := key()
+					ok = mit.Seek(seek)
+					pos = sort.SearchStrings(keys, string(seek))
+				default:
+					ok = mit.First()
+					pos = 0
+				}
+				want := pos >= 0 && pos < len(keys)
+				if ok != want {
+					t.Fatalf("round %d step %d: valid %v, want %v", round, step, ok, want)
+				}
+				if want && (string(mit.Key()) != keys[pos] || string(mit.Value()) != model[keys[pos]]) {
+					t.Fatalf("round %d step %d: %q=%q, want %q=%q", round, step, mit.Key(), mit.Value(), keys[pos], model[keys[pos]])
+				}
+			}
+			return nil
+		})
+	}
+}
@@ -0,0 +1,215 @@
+package emssdb
+
+import (
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"sync"
+	"time"
+)
+
+// Every commit of a Tx stamps the containers it touched with a new epoch of the db:
+// the key of kv and exkv, the name of hash, zset and queue.
+// The stamp of a removed container is deleted, the epochs only increase,
+// so a removed and recreated container does not get an old stamp back.
+
+const (
+	epochBlock = 1 << 20 // the epochs reserved at once
+)
+
+// epochs allocate the epochs of the commits. A block of them is reserved in the
+// meta key "epoch" before it is used, so a reopened db starts above all the used ones.
+type epochs struct {
+	mutex    sync.Mutex
+	db       *leveldb.DB
+	last     uint64 // the last epoch allocated
+	reserved uint64 // the epochs up to reserved are allocated without a write
+}
+
+func openEpochs(db *leveldb.DB) (ret *epochs, err error) {
+	val, err := db.Get(encodeMetaKey("epoch"), nil)
+	if err != nil && err != leveldb.ErrNotFound {
+		return nil, err
+	}
+	reserved := Bytes(val).GetUInt64()
+	return &epochs{db: db, last: reserved, reserved: reserved}, nil
+}
+
+func (e *epochs) next() (ret uint64, err error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if e.last == e.reserved {
+		reserved := e.reserved + epochBlock
+		if err = e.db.Put(encodeMetaKey("epoch"), NewByUInt64(reserved), &opt.WriteOptions{Sync: true}); err != nil {
+			return 0, err
+		}
+		e.reserved = reserved
+	}
+	e.last++
+	return e.last, nil
+}
+
+// [DTVERSION][DT][NAME]
+func encodeVersionKey(dt byte, name Bytes) (ret Bytes) {
+	buf := make(Bytes, 2+len(name))
+	buf[0] = DTVERSION
+	buf[1] = dt
+	copy(buf[2:], name)
+	return buf
+}
+
+func (v *view) version(vkey Bytes) (ret uint64) {
+	val, _ := v.r.Get(vkey, nil)
+	return Bytes(val).GetUInt64()
+}
+
+// Version return the version stamp of a container, 0 if it does not exist.
+// dt is one of DTKV, DTEXKV, DTHASH, DTZSET and DTQUEUE.
+func (v *view) Version(dt byte, name Bytes) (ret uint64) {
+	return v.version(encodeVersionKey(dt, name))
+}
+
+func (tx *Tx) touch(dt byte, name Bytes) {
+	tx.touched[string(encodeVersionKey(dt, name))] = true
+}
+
+// bumpVersions stamp the touched containers with a new epoch, or delete the stamps of the removed ones
+func (tx *Tx) bumpVersions() (err error) {
+	if len(tx.touched) == 0 {
+		return nil
+	}
+	epoch, err := tx.db.epochs.next()
+	if err != nil {
+		return err
+	}
+	for vkey := range tx.touched {
+		rkey := Bytes(vkey)
+		if _, err := tx.r.Get(encodeExistsKey(rkey[1], rkey[2:]), nil); err == nil {
+			tx.put(rkey, NewByUInt64(epoch))
+		} else {
+			tx.delete(rkey)
+		}
+	}
+	return nil
+}
+
+// encodeExistsKey the key which exists as long as the container dt/name does:
+// the key of kv and exkv, the size of hash, zset and queue
+func encodeExistsKey(dt byte, name Bytes) (ret Bytes) {
+	switch dt {
+	case DTKV:
+		return encodeKvKey(name)
+	case DTEXKV:
+		return encodeExkvKey(name)
+	case DTHASH:
+		return encodeHsizeKey(name)
+	case DTZSET:
+		return encodeZsizeKey(name)
+	}
+	return encodeQsizeKey(name)
+}
+
+// Watch reads from a snapshot and remembers the versions of the containers it read.
+// Its Update commits only if none of them has changed since, or returns ErrConflict
+// and the caller could retry with a new Watch.
+type Watch struct {
+	snap     *Snapshot
+	db       *DB
+	versions map[string]uint64
+}
+
+// Watch start a watch of the current DB, Release it after use
+func (d *DB) Watch() (ret *Watch, err error) {
+	defer d.metrics.observe("watch", time.Now(), &err)
+	snap, err := d.snapshot()
+	if err != nil {
+		return nil, err
+	}
+	snap.metrics = d.metrics
+	var w Watch
+	w.snap = snap
+	w.db = d
+	w.versions = make(map[string]uint64)
+	return &w, nil
+}
+
+// Release release the snapshot of the watch
+func (w *Watch) Release() {
+	w.snap.Release()
+}
+
+func (w *Watch) watch(dt byte, name Bytes) {
+	vkey := encodeVersionKey(dt, name)
+	w.versions[string(vkey)] = w.snap.version(vkey)
+}
+
+// Update run fn in a transaction if the watched containers are unchanged
+func (w *Watch) Update(fn func(tx *Tx) error) (err error) {
+	return w.db.Update(func(tx *Tx) error {
+		for vkey, ver := range w.versions {
+			if tx.version(Bytes(vkey)) != ver {
+				return ErrConflict
+			}
+		}
+		return fn(tx)
+	})
+}
+
+func (w *Watch) Get(key Bytes) (ret Bytes, err error) {
+	w.watch(DTKV, key)
+	return w.snap.Get(key)
+}
+
+func (w *Watch) Eget(key Bytes) (ret Bytes, stamp uint64, err error) {
+	w.watch(DTEXKV, key)
+	return w.snap.Eget(key)
+}
+
+func (w *Watch) Hget(name, key Bytes) (val Bytes, err error) {
+	w.watch(DTHASH, name)
+	return w.snap.Hget(name, key)
+}
+
+func (w *Watch) Hsize(name Bytes) (ret int64, err error) {
+	w.watch(DTHASH, name)
+	return w.snap.Hsize(name)
+}
+
+func (w *Watch) Hscan(name, start, end Bytes) (ret *HIterator) {
+	w.watch(DTHASH, name)
+	return w.snap.Hscan(name, start, end)
+}
+
+func (w *Watch) Zget(name, key Bytes) (score int64, err error) {
+	w.watch(DTZSET, name)
+	return w.snap.Zget(name, key)
+}
+
+func (w *Watch) Zsize(name Bytes) (ret int64, err error) {
+	w.watch(DTZSET, name)
+	return w.snap.Zsize(name)
+}
+
+func (w *Watch) Zscan(name Bytes, start, end int64) (ret *ZIterator) {
+	w.watch(DTZSET, name)
+	return w.snap.Zscan(name, start, end)
+}
+
+func (w *Watch) Qsize(name Bytes) (ret int64, err error) {
+	w.watch(DTQUEUE, name)
+	return w.snap.Qsize(name)
+}
+
+func (w *Watch) Qfront(name Bytes) (ret Bytes, err error) {
+	w.watch(DTQUEUE, name)
+	return w.snap.Qfront(name)
+}
+
+func (w *Watch) Qback(name Bytes) (ret Bytes, err error) {
+	w.watch(DTQUEUE, name)
+	return w.snap.Qback(name)
+}
+
+func (w *Watch) Qscan(name Bytes) (ret *QIterator) {
+	w.watch(DTQUEUE, name)
+	return w.snap.Qscan(name)
+}
@@ -0,0 +1,82 @@
+package emssdb
+
+import (
+	"github.com/syndtr/goleveldb/leveldb"
+	"testing"
+)
+
+func TestVersionDeleted(t *testing.T) {
+	d := openTestDB(t, Options{})
+	ns, _ := d.Namespace("ns")
+	for _, h := range []*DB{d, ns} {
+		h.Set(Bytes("k"), Bytes("v"))
+		h.Hset(Bytes("h"), Bytes("f"), Bytes("v"))
+		if h.Version(DTKV, Bytes("k")) == 0 || h.Version(DTHASH, Bytes("h")) == 0 {
+			t.Fatal("no stamps")
+		}
+		h.Del(Bytes("k"))
+		h.Hdel(Bytes("h"), Bytes("f"))
+		h.Del(Bytes("never"))
+		if h.Version(DTKV, Bytes("k")) != 0 || h.Version(DTHASH, Bytes("h")) != 0 {
+			t.Fatal("stamps left")
+		}
+	}
+	it := d.db.NewIterator(nil, nil)
+	defer it.Release()
+	for it.Next() {
+		if it.Key()[0] != DTMETA {
+			t.Errorf("key left %q", it.Key())
+		}
+	}
+}
+
+func TestWatchRecreated(t *testing.T) {
+	d := openTestDB(t, Options{})
+	d.Set(Bytes("k"), Bytes("a"))
+	w, _ := d.Watch()
+	defer w.Release()
+	w.Get(Bytes("k"))
+	d.Del(Bytes("k"))
+	d.Set(Bytes("k"), Bytes("a"))
+	if err := w.Update(func(tx *Tx) error { return tx.Set(Bytes("k"), Bytes("b")) }); err != ErrConflict {
+		t.Fatal(err)
+	}
+}
+
+func TestEpochsReopen(t *testing.T) {
+	path := t.TempDir()
+	d, err := OpenDB(Options{Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Set(Bytes("a"), Bytes("v"))
+	d.Set(Bytes("b"), Bytes("v"))
+	last := d.Version(DTKV, Bytes("b"))
+	d.Close()
+
+	d = openTestDB(t, Options{Path: path})
+	d.Set(Bytes("c"), Bytes("v"))
+	if ver := d.Version(DTKV, Bytes("c")); ver <= last {
+		t.Fatalf("epoch %d after %d", ver, last)
+	}
+}
+
+func TestRepairEpochError(t *testing.T) {
+	d := openTestDB(t, Options{})
+	d.Hset(Bytes("h"), Bytes("f"), Bytes("v"))
+	d.RawSet(encodeHsizeKey(Bytes("h")), NewByInt64(5))
+
+	// the next epoch reservation fails
+	ldb, err := leveldb.OpenFile(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ldb.Close()
+	d.epochs = &epochs{db: ldb}
+	if _, err := d.Repair(); err != leveldb.ErrClosed {
+		t.Fatal(err)
+	}
+	if size, _ := d.Hsize(Bytes("h")); size != 5 {
+		t.Fatal("repaired without the stamps", size)
+	}
+}
@@ -2,14 +2,44 @@ package emssdb
 
 import (
 	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"hash/fnv"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
-// Writer for batch operations
+const (
+	writerStripes = 256
+)
+
+// Writer commits the batches of the operations, and locks the containers they write.
+// An operation on one container (a kv key, a hash, a zset, a queue) holds
+// the stripe lock of that container, so writers of unrelated containers run concurrently.
+// An operation on many containers holds all of them.
+//
+// With GroupCommit the batches committed at the same time by concurrent writers
+// are coalesced into one leveldb write (and one fsync with Sync).
 type Writer struct {
-	db    *leveldb.DB
-	batch leveldb.Batch
-	Mutex sync.Mutex
+	db           *leveldb.DB
+	Sync         SyncPolicy
+	SyncInterval time.Duration
+	GroupCommit  bool
+	all          sync.RWMutex
+	stripes      [writerStripes]sync.Mutex
+	lastSync     int64 // unix nano of the last fsync with SyncByInterval
+	unsynced     int32 // 1 if a write was not fsynced since, with SyncByInterval
+
+	gmutex  sync.Mutex
+	pending []*commitReq
+	leading bool
+}
+
+// commitReq is a batch waiting for the group commit
+type commitReq struct {
+	batch *leveldb.Batch
+	done  chan error
+	lead  chan bool
 }
 
 // NewWriter return a leveldb batch writer
@@ -19,40 +49,143 @@ func NewWriter(db *leveldb.DB) *Writer {
 	return &w
 }
 
-// Begin before batch operation
-func (w *Writer) Begin() {
-	w.batch.Reset()
+func (w *Writer) stripe(dt byte, name []byte) (ret *sync.Mutex) {
+	h := fnv.New32a()
+	h.Write([]byte{dt})
+	h.Write(name)
+	return &w.stripes[h.Sum32()%writerStripes]
+}
+
+// Lock lock the container dt/name before writing it
+func (w *Writer) Lock(dt byte, name []byte) {
+	w.all.RLock()
+	w.stripe(dt, name).Lock()
 }
 
-// RollBack rollback the batch operations
-func (w *Writer) RollBack() {
-	w.batch.Reset()
+// Unlock unlock the container dt/name
+func (w *Writer) Unlock(dt byte, name []byte) {
+	w.stripe(dt, name).Unlock()
+	w.all.RUnlock()
+}
+
+// LockAll lock all the containers
+func (w *Writer) LockAll() {
+	w.all.Lock()
+}
+
+// UnlockAll unlock all the containers
+func (w *Writer) UnlockAll() {
+	w.all.Unlock()
+}
+
+// Commit write the batch
+func (w *Writer) Commit(batch *leveldb.Batch) (err error) {
+	if !w.GroupCommit {
+		return w.write(batch)
+	}
+
+	req := &commitReq{batch, make(chan error, 1), make(chan bool, 1)}
+	w.gmutex.Lock()
+	w.pending = append(w.pending, req)
+	if w.leading {
+		w.gmutex.Unlock()
+		select {
+		case err := <-req.done:
+			return err
+		case <-req.lead:
+		}
+	} else {
+		w.leading = true
+		w.gmutex.Unlock()
+	}
+
+	// the leader writes all the pending batches, and then hands over to the next one
+	w.gmutex.Lock()
+	group := w.pending
+	w.pending = nil
+	w.gmutex.Unlock()
+	w.commitGroup(group)
+	w.gmutex.Lock()
+	if len(w.pending) > 0 {
+		w.pending[0].lead <- true
+	} else {
+		w.leading = false
+	}
+	w.gmutex.Unlock()
+	return <-req.done
 }
 
-// Commit commit all operations
-func (w *Writer) Commit() (err error) {
-	//var writeOpts opt.WriteOptions
-	return w.db.Write(&w.batch, nil)
+func (w *Writer) commitGroup(group []*commitReq) {
+	if len(group) == 1 {
+		group[0].done <- w.write(group[0].batch)
+		return
+	}
+	var merged leveldb.Batch
+	for _, req := range group {
+		req.batch.Replay(&merged)
+	}
+	if err := w.write(&merged); err == nil {
+		for _, req := range group {
+			req.done <- nil
+		}
+		return
+	}
+	// the group failed, write the batches one by one to give each caller its own result
+	for _, req := range group {
+		req.done <- w.write(req.batch)
+	}
 }
 
-// Put add a set operation
-func (w *Writer) Put(key []byte, val []byte) {
-	w.batch.Put(key, val)
+func (w *Writer) write(batch *leveldb.Batch) (err error) {
+	var writeOpts opt.WriteOptions
+	writeOpts.Sync = w.needSync()
+	if err = w.db.Write(batch, &writeOpts); err == nil && !writeOpts.Sync && w.Sync == SyncByInterval {
+		atomic.StoreInt32(&w.unsynced, 1)
+	}
+	return err
 }
 
-// Delete add a delete operation
-func (w *Writer) Delete(key []byte) {
-	w.batch.Delete(key)
+// syncPending fsync the writes left unsynced by SyncByInterval, if any
+func (w *Writer) syncPending() (err error) {
+	if !atomic.CompareAndSwapInt32(&w.unsynced, 1, 0) {
+		return nil
+	}
+	atomic.StoreInt64(&w.lastSync, time.Now().UnixNano())
+	// the fsync of a write syncs the journal with all the writes before it
+	if err = w.db.Delete(encodeMetaKey("sync"), &opt.WriteOptions{Sync: true}); err != nil {
+		atomic.StoreInt32(&w.unsynced, 1)
+	}
+	return err
 }
 
-// Do try to do all operations
-func (w *Writer) Do() {
-	w.Mutex.Lock()
-	w.Begin()
+// needSync tell whether the next write should be fsynced by the sync policy
+func (w *Writer) needSync() bool {
+	switch w.Sync {
+	case SyncEveryWrite:
+		return true
+	case SyncByInterval:
+		now := time.Now().UnixNano()
+		last := atomic.LoadInt64(&w.lastSync)
+		if now-last < int64(w.SyncInterval) {
+			return false
+		}
+		return atomic.CompareAndSwapInt64(&w.lastSync, last, now)
+	}
+	return false
 }
 
-// Done end the batch operations
-func (w *Writer) Done() {
-	w.RollBack()
-	w.Mutex.Unlock()
+// syncDaemon fsync the writes left unsynced by SyncByInterval every SyncInterval, and at Close
+func (db *DB) syncDaemon() {
+	defer db.waitgroup.Done()
+	ticker := time.NewTicker(db.writer.SyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-db.quit:
+			db.writer.syncPending()
+			return
+		case <-ticker.C:
+			db.writer.syncPending()
+		}
+	}
 }
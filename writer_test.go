@@ -0,0 +1,154 @@
+package emssdb
+
+import (
+	"fmt"
+	"github.com/syndtr/goleveldb/leveldb"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSyncByIntervalPending(t *testing.T) {
+	d := openTestDB(t, Options{Sync: SyncByInterval, SyncInterval: 200 * time.Millisecond})
+	for i := 0; i < 3; i++ {
+		d.Set(Bytes("k"), Bytes("v"))
+	}
+	if atomic.LoadInt32(&d.writer.unsynced) != 1 {
+		t.Fatal("the writes after the first one are synced")
+	}
+	time.Sleep(500 * time.Millisecond)
+	if atomic.LoadInt32(&d.writer.unsynced) != 0 {
+		t.Fatal("the writes left unsynced")
+	}
+}
+
+func TestRawSetSync(t *testing.T) {
+	d := openTestDB(t, Options{Sync: SyncByInterval, SyncInterval: time.Hour})
+	d.RawSet(Bytes("a"), Bytes("1"))
+	d.RawSet(Bytes("b"), Bytes("2"))
+	if atomic.LoadInt32(&d.writer.unsynced) != 1 {
+		t.Fatal("the raw writes bypass the sync policy")
+	}
+	d.RawDel(Bytes("a"))
+	if _, err := d.RawGet(Bytes("a")); err != ErrNotFound {
+		t.Fatal(err)
+	}
+	if val, err := d.RawGet(Bytes("b")); err != nil || string(val) != "2" {
+		t.Fatal(val, err)
+	}
+
+	path := t.TempDir()
+	openTestDB(t, Options{Path: path}).Close()
+	ro := openTestDB(t, Options{Path: path, ReadOnly: true})
+	if err := ro.RawSet(Bytes("a"), Bytes("1")); err != ErrReadOnly {
+		t.Fatal(err)
+	}
+}
+
+// TestStripedWriters run concurrent writers over a few shared containers,
+// the size counters must match the items after them
+func TestStripedWriters(t *testing.T) {
+	d := openTestDB(t, Options{})
+	const writers, ops = 8, 300
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < ops; i++ {
+				name := Bytes(fmt.Sprint("c", i%3))
+				field := Bytes(fmt.Sprint("f", (w*ops+i)%50))
+				switch i % 6 {
+				case 0:
+					d.Hset(name, field, Bytes("v"))
+				case 1:
+					d.Hdel(name, field)
+				case 2:
+					d.Zset(name, field, int64(i))
+				case 3:
+					d.Zdel(name, field)
+				case 4:
+					d.QpushBack(name, field)
+				case 5:
+					d.QpopFront(name)
+				}
+				d.Incr(Bytes("n"), 1)
+			}
+		}(w)
+	}
+	wg.Wait()
+	if n, _ := d.Get(Bytes("n")); n.GetInt64() != writers*ops {
+		t.Fatal("incr", n.GetInt64())
+	}
+	checkClean(t, d)
+}
+
+// TestGroupCommit run concurrent writers through the group commit, every one
+// gets its own result and all the writes land
+func TestGroupCommit(t *testing.T) {
+	d := openTestDB(t, Options{GroupCommit: true, Sync: SyncEveryWrite})
+	const writers, ops = 16, 50
+	var wg sync.WaitGroup
+	errs := make(chan error, writers*ops)
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < ops; i++ {
+				errs <- d.Hset(Bytes(fmt.Sprint("h", w)), Bytes(fmt.Sprint("f", i)), Bytes("v"))
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for w := 0; w < writers; w++ {
+		if size, _ := d.Hsize(Bytes(fmt.Sprint("h", w))); size != ops {
+			t.Fatal("hsize", w, size)
+		}
+	}
+	checkClean(t, d)
+}
+
+// TestGroupCommitError check that a failed group write is returned to all
+// its writers, and that the leadership is handed over after it
+func TestGroupCommitError(t *testing.T) {
+	ldb, err := leveldb.OpenFile(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ldb.Close()
+	w := NewWriter(ldb)
+	w.GroupCommit = true
+	var wg sync.WaitGroup
+	var failed int32
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var batch leveldb.Batch
+			batch.Put(Bytes("k"), Bytes("v"))
+			if w.Commit(&batch) == leveldb.ErrClosed {
+				atomic.AddInt32(&failed, 1)
+			}
+		}()
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("the writers of the group are blocked")
+	}
+	if failed != 32 || w.leading || len(w.pending) != 0 {
+		t.Fatal(failed, w.leading, len(w.pending))
+	}
+}